@@ -0,0 +1,294 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// getArchiveType the type of the archive from the file name, "" if it is not an archive
+func getArchiveType(name string) string {
+	name = strings.ToLower(name)
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return "zip"
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return "tar.gz"
+	case strings.HasSuffix(name, ".tar"):
+		return "tar"
+	}
+	return ""
+}
+
+// AttachmentArchive the archive of "--attachments-archive", the downloaded files are written into it one by one.
+// The files are streamed into the archive, so the files with the same content but different file keys are stored twice:
+// their hash is known only after they are written.
+type AttachmentArchive struct {
+	file  *os.File
+	zip   *zip.Writer
+	gzip  *gzip.Writer
+	tar   *tar.Writer
+	mu    sync.Mutex
+	names map[string]bool
+}
+
+func createAttachmentArchive(path string) (*AttachmentArchive, error) {
+	archiveType := getArchiveType(path)
+	if archiveType == "" {
+		return nil, fmt.Errorf("The archive %s must be a .zip, .tar or .tar.gz file", path)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	archive := &AttachmentArchive{file: file, names: make(map[string]bool)}
+	switch archiveType {
+	case "zip":
+		archive.zip = zip.NewWriter(file)
+	case "tar.gz":
+		archive.gzip = gzip.NewWriter(file)
+		archive.tar = tar.NewWriter(archive.gzip)
+	default:
+		archive.tar = tar.NewWriter(file)
+	}
+	return archive, nil
+}
+
+// AddFile writes the file at path into the archive as name
+func (archive *AttachmentArchive) AddFile(name, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return archive.Write(name, info.Size(), file)
+}
+
+// Write writes size bytes read from reader into the archive as name, a file at a time.
+// The size is needed before the content by a tar archive.
+func (archive *AttachmentArchive) Write(name string, size int64, reader io.Reader) error {
+	name = filepath.ToSlash(name)
+	archive.mu.Lock()
+	defer archive.mu.Unlock()
+	if archive.names[name] {
+		// the same file in another field
+		return nil
+	}
+	archive.names[name] = true
+
+	var writer io.Writer
+	if archive.zip != nil {
+		header := &zip.FileHeader{Name: name, Method: zip.Deflate, Modified: time.Now()}
+		header.SetMode(0666)
+		var err error
+		writer, err = archive.zip.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+	} else {
+		header := &tar.Header{Typeflag: tar.TypeReg, Name: name, Size: size, Mode: 0666, ModTime: time.Now()}
+		if err := archive.tar.WriteHeader(header); err != nil {
+			return err
+		}
+		writer = archive.tar
+	}
+	written, err := io.Copy(writer, reader)
+	if err != nil {
+		return err
+	}
+	if written != size {
+		return fmt.Errorf("The file %s is %d bytes, but %d bytes were read", name, size, written)
+	}
+	return nil
+}
+
+// Close writes the end of the archive
+func (archive *AttachmentArchive) Close() error {
+	var err error
+	if archive.zip != nil {
+		err = archive.zip.Close()
+	} else {
+		err = archive.tar.Close()
+		if archive.gzip != nil && err == nil {
+			err = archive.gzip.Close()
+		}
+	}
+	if errClose := archive.file.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// attachmentSource the files of "-b" read by the import, from a directory or an archive
+type attachmentSource struct {
+	dir   string // the directory, or the directory the tar archive was extracted to
+	tmp   bool   // the directory is removed by closeAttachments
+	zip   *zip.ReadCloser
+	files map[string]*zip.File
+}
+
+var (
+	attachments     *attachmentSource
+	attachmentsErr  error
+	attachmentsOnce sync.Once
+)
+
+// getAttachmentSource opens the directory or the archive of "-b" once
+func getAttachmentSource() (*attachmentSource, error) {
+	attachmentsOnce.Do(func() {
+		attachments, attachmentsErr = openAttachmentSource(config.FileDir)
+	})
+	return attachments, attachmentsErr
+}
+
+func openAttachmentSource(path string) (*attachmentSource, error) {
+	info, err := os.Stat(path)
+	archiveType := getArchiveType(path)
+	if err != nil || info.IsDir() || archiveType == "" {
+		// the files are checked when they are opened
+		return &attachmentSource{dir: path}, nil
+	}
+	if archiveType == "zip" {
+		reader, err := zip.OpenReader(path)
+		if err != nil {
+			return nil, err
+		}
+		source := &attachmentSource{zip: reader, files: make(map[string]*zip.File)}
+		for _, file := range reader.File {
+			source.files[file.Name] = file
+		}
+		return source, nil
+	}
+
+	// a tar archive cannot be read at random, extract it
+	dir, err := ioutil.TempDir("", "cli-kintone")
+	if err != nil {
+		return nil, err
+	}
+	if err := extractTar(path, archiveType == "tar.gz", dir); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	return &attachmentSource{dir: dir, tmp: true}, nil
+}
+
+func extractTar(path string, isGzip bool, dir string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	var reader io.Reader = file
+	if isGzip {
+		gzipReader, err := gzip.NewReader(file)
+		if err != nil {
+			return err
+		}
+		defer gzipReader.Close()
+		reader = gzipReader
+	}
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name, err := getArchiveFilePath(dir, header.Name)
+		if err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				return err
+			}
+			output, err := os.Create(name)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(output, tarReader)
+			if errClose := output.Close(); err == nil {
+				err = errClose
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			target, err := getArchiveFilePath(dir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(name), 0777); err != nil {
+				return err
+			}
+			if err := os.Link(target, name); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// getArchiveFilePath the path of a file of the archive extracted to dir, which must be in dir
+func getArchiveFilePath(dir, name string) (string, error) {
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if !strings.HasPrefix(path, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return "", fmt.Errorf("The archive has an invalid file name %s", name)
+	}
+	return path, nil
+}
+
+// Open opens the file of the path written in the input file
+func (source *attachmentSource) Open(name string) (io.ReadCloser, int64, error) {
+	if source.zip != nil && !filepath.IsAbs(name) {
+		file := source.files[filepath.ToSlash(filepath.Clean(name))]
+		if file == nil {
+			return nil, 0, fmt.Errorf("%s is not found in %s", name, config.FileDir)
+		}
+		reader, err := file.Open()
+		return reader, int64(file.UncompressedSize64), err
+	}
+
+	path := name
+	if !filepath.IsAbs(name) {
+		path = filepath.Join(source.dir, name)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, 0, err
+	}
+	return file, info.Size(), nil
+}
+
+// closeAttachments removes the files extracted from the archive of "-b"
+func closeAttachments() {
+	if attachments == nil {
+		return
+	}
+	if attachments.zip != nil {
+		attachments.zip.Close()
+	}
+	if attachments.tmp {
+		os.RemoveAll(attachments.dir)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestAttachmentArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+
+	file := filepath.Join(dir, "a.txt")
+	ioutil.WriteFile(file, []byte("same"), 0644)
+	for _, name := range []string{"out.zip", "out.tar", "out.tar.gz"} {
+		path := filepath.Join(dir, name)
+		archive, err := createAttachmentArchive(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.AddFile(filepath.Join("file-1", "a.txt"), file); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.Write(filepath.Join("file-2", "a.txt"), 4, strings.NewReader("same")); err != nil {
+			t.Fatal(err)
+		}
+		if err := archive.Write(filepath.Join("file-3", "a.txt"), 4, strings.NewReader("short")); err == nil {
+			t.Errorf("%s: a file of another size must not be written", name)
+		}
+		if err := archive.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		config.FileDir = path
+		source, err := openAttachmentSource(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, file := range []string{filepath.Join("file-1", "a.txt"), filepath.Join("file-2", "a.txt")} {
+			reader, size, err := source.Open(file)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			data, _ := ioutil.ReadAll(reader)
+			reader.Close()
+			if string(data) != "same" || size != 4 {
+				t.Errorf("%s: %s must be read from the archive but %q (%d bytes)", name, file, string(data), size)
+			}
+		}
+		if _, _, err := source.Open("file-3/b.txt"); err == nil {
+			t.Errorf("%s: a file not in the archive must not be found", name)
+		}
+		attachments = source
+		closeAttachments()
+		attachments = nil
+	}
+
+	if _, err := getArchiveFilePath(dir, "../a.txt"); err == nil {
+		t.Error("a file out of the directory must not be extracted")
+	}
+}
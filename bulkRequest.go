@@ -8,12 +8,14 @@ import (
 	"fmt"
 	"io"
 	"io/ioutil"
+	"log"
 	"mime"
 	"net/http"
 	"net/http/cookiejar"
 	"net/url"
-	"os"
 	"reflect"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/kintone-labs/go-kintone"
@@ -39,7 +41,12 @@ type BulkRequestItem struct {
 
 // BulkRequests BulkRequests structure
 type BulkRequests struct {
-	Requests []*BulkRequestItem `json:"requests,string"`
+	Requests []*BulkRequestItem                     `json:"requests,string"`
+	Sources  map[*kintone.Record]*BulkRequestSource `json:"-"`
+	// the requests processed by kintone although the response was lost, which are not sent again
+	Committed []*BulkRequestItem `json:"-"`
+	// the records of the last failed request were searched and not found, it can be sent again
+	notAdded bool
 }
 
 // BulkRequestsError structure
@@ -70,7 +77,7 @@ type DataRequestRecordsPOST struct {
 	Records []*kintone.Record `json:"records"`
 }
 
-//DataRequestRecordPUT structure
+// DataRequestRecordPUT structure
 type DataRequestRecordPUT struct {
 	ID     uint64          `json:"id,string"`
 	Record *kintone.Record `json:"record,string"`
@@ -105,8 +112,52 @@ func (recordsPut *DataRequestRecordsPUT) SetRecordWithKey(record *kintone.Record
 
 }
 
-// Request bulkRequest with multi method which included only one request
+// Request bulkRequest with multi method which included only one request.
+// When it is unknown if kintone processed the request, only the requests whose records are not found are sent again.
 func (bulk *BulkRequests) Request(app *kintone.App) (*DataResponseBulkPOST, interface{}) {
+	policy := newRetryPolicy()
+	attempts := policy.Attempts()
+	if attempts == 1 {
+		return bulk.request(app)
+	}
+
+	inserted, _ := bulk.CountRecords()
+	lastID, errLast := uint64(0), error(nil)
+	if inserted > 0 {
+		lastID, errLast = getLastRecordID(app)
+	}
+
+	for attempt := uint(1); ; attempt++ {
+		bulk.notAdded = false
+		resp, err := bulk.request(app)
+		if err == nil || !isAmbiguousError(err) {
+			return resp, err
+		}
+		if inserted, _ := countRequestRecords(bulk.Requests); inserted > 0 {
+			if errLast != nil {
+				log.Printf("The bulkRequest is not sent again because the added records cannot be searched: %v", errLast)
+				return resp, err
+			}
+			if errCheck := bulk.removeAdded(app, lastID); errCheck != nil {
+				log.Printf("The bulkRequest is not sent again because the added records cannot be searched: %v", errCheck)
+				return resp, err
+			}
+			if len(bulk.Requests) == 0 {
+				log.Printf("The bulkRequest failed with %v but its records were added", err)
+				return &DataResponseBulkPOST{}, nil
+			}
+		}
+		bulk.notAdded = true
+		if attempt >= attempts {
+			return resp, err
+		}
+		wait := policy.Wait(attempt, 0)
+		log.Printf("Retry %d/%d in %v: bulkRequest: %v", attempt, attempts-1, wait, err)
+		time.Sleep(wait)
+	}
+}
+
+func (bulk *BulkRequests) request(app *kintone.App) (*DataResponseBulkPOST, interface{}) {
 
 	data, _ := json.Marshal(bulk)
 	req, err := newRequest(app, "POST", "bulkRequest", bytes.NewReader(data))
@@ -218,6 +269,73 @@ func (bulk *BulkRequests) ImportDataInsert(app *kintone.App, recordData *kintone
 
 }
 
+// Reset remove all the requests
+func (bulk *BulkRequests) Reset() {
+	bulk.Requests = bulk.Requests[:0]
+	bulk.Sources = nil
+	bulk.Committed = nil
+}
+
+// Split split the bulkRequest into bulkRequests which have only one record
+func (bulk *BulkRequests) Split() []*BulkRequests {
+	singles := make([]*BulkRequests, 0)
+	addSingle := func(bulkReqItem *BulkRequestItem, record *kintone.Record, payload interface{}) {
+		single := &BulkRequests{Requests: []*BulkRequestItem{{bulkReqItem.Method, bulkReqItem.API, payload}}}
+		if source, ok := bulk.Sources[record]; ok {
+			single.SetSource(record, source)
+		}
+		singles = append(singles, single)
+	}
+
+	for _, bulkReqItem := range bulk.Requests {
+		switch payload := bulkReqItem.Payload.(type) {
+		case *DataRequestRecordsPOST:
+			for _, record := range payload.Records {
+				addSingle(bulkReqItem, record, &DataRequestRecordsPOST{payload.App, []*kintone.Record{record}})
+			}
+		case *DataRequestRecordsPUT:
+			for _, recordPUT := range payload.Records {
+				addSingle(bulkReqItem, getRecordPUT(recordPUT), &DataRequestRecordsPUT{payload.App, []interface{}{recordPUT}})
+			}
+		}
+	}
+	return singles
+}
+
+// IsFull reports whether the bulkRequest has limit records, or no room for the next record
+func (bulk *BulkRequests) IsFull(limit int) bool {
+	inserted, updated := bulk.CountRecords()
+	if inserted+updated >= limit {
+		return true
+	}
+	if len(bulk.Requests) < ConstBulkRequestLimitRequest {
+		return false
+	}
+	// no request can be added, the next record needs room in a request of its method
+	return inserted%ConstRecordsLimitPerRequest == 0 || updated%ConstRecordsLimitPerRequest == 0
+}
+
+// CountRecords count the records to be inserted and updated by the bulkRequest
+func (bulk *BulkRequests) CountRecords() (int, int) {
+	inserted, updated := countRequestRecords(bulk.Requests)
+	committedInserted, committedUpdated := countRequestRecords(bulk.Committed)
+	return inserted + committedInserted, updated + committedUpdated
+}
+
+// countRequestRecords count the records to be inserted and updated by the requests
+func countRequestRecords(requests []*BulkRequestItem) (int, int) {
+	inserted, updated := 0, 0
+	for _, bulkReqItem := range requests {
+		switch payload := bulkReqItem.Payload.(type) {
+		case *DataRequestRecordsPOST:
+			inserted += len(payload.Records)
+		case *DataRequestRecordsPUT:
+			updated += len(payload.Records)
+		}
+	}
+	return inserted, updated
+}
+
 // kintoneURLPath get path URL of kintone api
 func kintoneURLPath(apiName string, GuestSpaceID uint64) string {
 	var path string
@@ -231,6 +349,7 @@ func kintoneURLPath(apiName string, GuestSpaceID uint64) string {
 
 func newRequest(app *kintone.App, method, api string, body io.Reader) (*http.Request, error) {
 	path := kintoneURLPath(api, app.GuestSpaceId)
+	// sent to the URL of "--base-url" by BaseURLTransport, as the requests of go-kintone
 	u := url.URL{
 		Scheme: "https",
 		Host:   app.Domain,
@@ -257,18 +376,30 @@ func newRequest(app *kintone.App, method, api string, body io.Reader) (*http.Req
 	return req, nil
 }
 
-// Do Request to webservice
-func Do(app *kintone.App, req *http.Request) (*http.Response, error) {
+// initClient set the default client and timeout of the app, before it is used by several goroutines
+func initClient(app *kintone.App) error {
 	if app.Client == nil {
 		jar, err := cookiejar.New(nil)
 		if err != nil {
-			return nil, err
+			return err
+		}
+		transport, err := getTransport()
+		if err != nil {
+			return err
 		}
-		app.Client = &http.Client{Jar: jar}
+		app.Client = &http.Client{Jar: jar, Transport: transport}
 	}
 	if app.Timeout == time.Duration(0) {
 		app.Timeout = kintone.DEFAULT_TIMEOUT
 	}
+	return nil
+}
+
+// Do Request to webservice
+func Do(app *kintone.App, req *http.Request) (*http.Response, error) {
+	if err := initClient(app); err != nil {
+		return nil, err
+	}
 
 	type result struct {
 		resp *http.Response
@@ -348,6 +479,7 @@ type ErrorResponse struct {
 	Status  string
 	Message string
 	Errors  interface{}
+	Locate  func(key string) string
 }
 
 func (err *ErrorResponse) show(prefix string) {
@@ -363,7 +495,15 @@ func (err *ErrorResponse) show(prefix string) {
 		for indx, val := range err.Errors.(map[string]interface{}) {
 			fieldMessage := val.(map[string]interface{})
 			detailMessage := fieldMessage["messages"].([]interface{})
-			fmt.Printf("%v  '%v': ", prefix, indx)
+			location := ""
+			if err.Locate != nil {
+				location = err.Locate(indx)
+			}
+			if location != "" {
+				fmt.Printf("%v  %v: ", prefix, location)
+			} else {
+				fmt.Printf("%v  '%v': ", prefix, indx)
+			}
 			for i, mess := range detailMessage {
 				if i > 0 {
 					fmt.Printf(", ")
@@ -379,13 +519,66 @@ func (err *ErrorResponse) show(prefix string) {
 
 }
 
-// HandelResponse for bulkRequest
-func (bulk *BulkRequests) HandelResponse(rep *DataResponseBulkPOST, err interface{}, lastRowImport, rowNumber uint64) {
+// getErrorDetail get the error code and the message of a failed request
+func (bulk *BulkRequests) getErrorDetail(err interface{}) (string, string) {
+	var errorItem *BulkRequestsError
+	index := 0
+	switch e := err.(type) {
+	case *BulkRequestsErrors:
+		for idx, result := range e.Results {
+			if result.Code != "" {
+				errorItem = result
+				index = idx
+				break
+			}
+		}
+	case *BulkRequestsError:
+		errorItem = e
+	}
+	if errorItem == nil {
+		return "", fmt.Sprint(err)
+	}
+
+	message := errorItem.Message
+	if details, ok := errorItem.Errors.(map[string]interface{}); ok {
+		keys := make([]string, 0, len(details))
+		for key := range details {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			fieldMessage, _ := details[key].(map[string]interface{})
+			detailMessage, _ := fieldMessage["messages"].([]interface{})
+			messages := make([]string, 0, len(detailMessage))
+			for _, mess := range detailMessage {
+				messages = append(messages, fmt.Sprint(mess))
+			}
+			if location := bulk.LocateError(index, key); location != "" {
+				message += fmt.Sprintf(" %s: %s", location, strings.Join(messages, ", "))
+			} else {
+				message += fmt.Sprintf(" '%s': %s", key, strings.Join(messages, ", "))
+			}
+		}
+	}
+	return errorItem.Code, message
+}
+
+// HandelResponse for bulkRequest, returns an error which stops the import when the bulkRequest failed
+func (bulk *BulkRequests) HandelResponse(rep *DataResponseBulkPOST, err interface{}, lastRowImport, rowNumber uint64) error {
 
 	if err != nil {
 		fmt.Printf(" => ERROR OCCURRED\n")
 		CLIMessage := fmt.Sprintf("ERROR.\nFor error details, please read the details above.\n")
-		CLIMessage += fmt.Sprintf("Lines %d to %d of the imported file contain errors. Please fix the errors on the file, and re-import it with the flag \"-l %d\"\n", lastRowImport, rowNumber, lastRowImport)
+		// "-l" is the position of the record, which differs from the line when the values or the subtables have several lines
+		position := fmt.Sprintf("Records %d to %d", lastRowImport, rowNumber)
+		if firstLine, lastLine := bulk.Lines(); firstLine > 0 {
+			position += fmt.Sprintf(" (lines %d to %d)", firstLine, lastLine)
+		}
+		if config.Checkpoint != "" {
+			CLIMessage += fmt.Sprintf("%s of the imported file contain errors. Please fix the errors on the file, remove the checkpoint file \"%s\", and re-import it with the flag \"-l %d\"\n", position, config.Checkpoint, lastRowImport)
+		} else {
+			CLIMessage += fmt.Sprintf("%s of the imported file contain errors. Please fix the errors on the file, and re-import it with the flag \"-l %d\"\n", position, lastRowImport)
+		}
 
 		method := map[string]string{"POST": "INSERT", "PUT": "UPDATE"}
 		methodOccuredError := ""
@@ -403,6 +596,9 @@ func (bulk *BulkRequests) HandelResponse(rep *DataResponseBulkPOST, err interfac
 				errorResp.Errors = err.(*BulkRequestsError).Errors
 				errorResp.ID = err.(*BulkRequestsError).ID
 				errorResp.Code = err.(*BulkRequestsError).Code
+				errorResp.Locate = func(key string) string {
+					return bulk.LocateError(0, key)
+				}
 				errorResp.show("")
 			}
 		} else {
@@ -417,6 +613,10 @@ func (bulk *BulkRequests) HandelResponse(rep *DataResponseBulkPOST, err interfac
 				errorResp.Status = errorsResp.HTTPStatus
 				errorResp.Message = errorItem.Message
 				errorResp.Errors = errorItem.Errors
+				index := idx
+				errorResp.Locate = func(key string) string {
+					return bulk.LocateError(index, key)
+				}
 
 				errorResp.show("")
 				methodOccuredError = method[bulk.Requests[idx].Method]
@@ -427,9 +627,10 @@ func (bulk *BulkRequests) HandelResponse(rep *DataResponseBulkPOST, err interfac
 		if CLIMessage != "" {
 			fmt.Println(methodOccuredError, CLIMessage)
 		}
-		os.Exit(1)
+		return fmt.Errorf("The import was stopped by an error of the records %d to %d.", lastRowImport, rowNumber)
 	}
 	fmt.Println(" => SUCCESS")
+	return nil
 }
 func showTimeLog() {
 	fmt.Printf("%v: ", time.Now().Format("[2006-01-02 15:04:05]"))
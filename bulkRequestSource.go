@@ -0,0 +1,177 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// BulkRequestSource the source of a record in the input file
+type BulkRequestSource struct {
+	Row     uint64           // position of the record in the input file (the value of "-l")
+	Lines   []uint64         // line number where each row starts, 0 if unknown
+	Rows    [][]string       // rows of the CSV file
+	Columns map[string]int   // column index of each field code of the CSV file
+	SubRows map[string][]int // index of the row of each subtable row, by subtable field code
+	Data    json.RawMessage  // record of the JSON file
+	files   *recordFiles     // attachment files of the record, nil without them
+}
+
+// errorKeyRegexp matches the keys of the errors returned by kintone, e.g.
+// "records[37].price.value" or "records[3].table.value[2].value.price.value"
+var errorKeyRegexp = regexp.MustCompile(`^(?:requests\[(\d+)\]\.payload\.)?records?(?:\[(\d+)\])?\.([^.\[]+)(?:\.value\[(\d+)\]\.value\.([^.\[]+))?`)
+
+// countLineBreaks count the line breaks in the values of a CSV row,
+// so that the next row starts at (line of this row) + 1 + countLineBreaks(row).
+// Blank lines are skipped by the CSV reader and are not counted.
+func countLineBreaks(row []string) uint64 {
+	var count uint64
+	for _, col := range row {
+		count += uint64(strings.Count(col, "\n"))
+	}
+	return count
+}
+
+// FirstLine line number where the record starts, 0 if unknown
+func (source *BulkRequestSource) FirstLine() uint64 {
+	if len(source.Lines) == 0 {
+		return 0
+	}
+	return source.Lines[0]
+}
+
+// LastLine line number where the record ends, 0 if unknown
+func (source *BulkRequestSource) LastLine() uint64 {
+	if len(source.Lines) == 0 {
+		return 0
+	}
+	last := len(source.Lines) - 1
+	if last < len(source.Rows) {
+		return source.Lines[last] + countLineBreaks(source.Rows[last])
+	}
+	return source.Lines[last]
+}
+
+// Position describes the lines of the record in the input file
+func (source *BulkRequestSource) Position() string {
+	first, last := source.FirstLine(), source.LastLine()
+	if first == 0 {
+		return fmt.Sprintf("record %d", source.Row)
+	}
+	if first == last {
+		return fmt.Sprintf("line %d", first)
+	}
+	return fmt.Sprintf("lines %d - %d", first, last)
+}
+
+// Locate describes the position of a field of the record, subRow is -1 if the field is not in a subtable
+func (source *BulkRequestSource) Locate(table string, subRow int, code string) string {
+	line := source.FirstLine()
+	if subRow >= 0 {
+		rows := source.SubRows[table]
+		if subRow < len(rows) && rows[subRow] < len(source.Lines) {
+			line = source.Lines[rows[subRow]]
+		}
+	}
+	position := fmt.Sprintf("record %d", source.Row)
+	if line > 0 {
+		position = fmt.Sprintf("line %d", line)
+	}
+	if col, ok := source.Columns[code]; ok {
+		return fmt.Sprintf("%s, column `%s` (col %d)", position, code, col+1)
+	}
+	return fmt.Sprintf("%s, field `%s`", position, code)
+}
+
+// SetSource set the source of the record in the input file
+func (bulk *BulkRequests) SetSource(record *kintone.Record, source *BulkRequestSource) {
+	if bulk.Sources == nil {
+		bulk.Sources = make(map[*kintone.Record]*BulkRequestSource)
+	}
+	bulk.Sources[record] = source
+}
+
+// Lines the first and the last lines of the records of the bulkRequest in the input file, 0 if unknown
+func (bulk *BulkRequests) Lines() (uint64, uint64) {
+	var first, last uint64
+	for _, source := range bulk.Sources {
+		if source.FirstLine() == 0 {
+			return 0, 0
+		}
+		if first == 0 || source.FirstLine() < first {
+			first = source.FirstLine()
+		}
+		if source.LastLine() > last {
+			last = source.LastLine()
+		}
+	}
+	return first, last
+}
+
+// getUploadError the error of the attachment files of the records, nil if they were uploaded
+func (bulk *BulkRequests) getUploadError() error {
+	for _, source := range bulk.Sources {
+		if source.files != nil && source.files.err != nil {
+			return &FileUploadError{source.files.err}
+		}
+	}
+	return nil
+}
+
+func getRecordPUT(recordPUT interface{}) *kintone.Record {
+	switch r := recordPUT.(type) {
+	case *DataRequestRecordPUT:
+		return r.Record
+	case *DataRequestRecordPUTByKey:
+		return r.Record
+	}
+	return nil
+}
+
+// getRecord get the record at recordIndex of the request at index
+func (bulk *BulkRequests) getRecord(index, recordIndex int) *kintone.Record {
+	if index < 0 || index >= len(bulk.Requests) || recordIndex < 0 {
+		return nil
+	}
+	switch payload := bulk.Requests[index].Payload.(type) {
+	case *DataRequestRecordsPOST:
+		if recordIndex < len(payload.Records) {
+			return payload.Records[recordIndex]
+		}
+	case *DataRequestRecordsPUT:
+		if recordIndex < len(payload.Records) {
+			return getRecordPUT(payload.Records[recordIndex])
+		}
+	}
+	return nil
+}
+
+// LocateError translate the key of an error of the request at index into the position in the input file.
+// It returns "" if the position is unknown.
+func (bulk *BulkRequests) LocateError(index int, key string) string {
+	match := errorKeyRegexp.FindStringSubmatch(key)
+	if match == nil {
+		return ""
+	}
+	if match[1] != "" {
+		index, _ = strconv.Atoi(match[1])
+	}
+	recordIndex := 0
+	if match[2] != "" {
+		recordIndex, _ = strconv.Atoi(match[2])
+	}
+	source := bulk.Sources[bulk.getRecord(index, recordIndex)]
+	if source == nil {
+		return ""
+	}
+
+	if match[5] != "" {
+		subRow, _ := strconv.Atoi(match[4])
+		return source.Locate(match[3], subRow, match[5])
+	}
+	return source.Locate("", -1, match[3])
+}
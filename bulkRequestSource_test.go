@@ -0,0 +1,63 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+func TestCountLineBreaks(t *testing.T) {
+	if count := countLineBreaks([]string{"a", "b\nc", "d\ne\nf"}); count != 3 {
+		t.Errorf("countLineBreaks must be 3 but %d", count)
+	}
+}
+
+func TestLocateError(t *testing.T) {
+	bulkReq := &BulkRequests{}
+	app := &kintone.App{AppId: 1}
+	columns := map[string]int{"*": 0, "Text": 1, "price": 6, "Table": 7, "Table_price": 8}
+
+	first := kintone.NewRecord(map[string]interface{}{"Text": kintone.SingleLineTextField("a")})
+	bulkReq.SetSource(first, &BulkRequestSource{Row: 2, Lines: []uint64{2}, Rows: [][]string{{"*", "a\nb"}}, Columns: columns})
+	bulkReq.ImportDataInsert(app, first)
+
+	second := kintone.NewRecord(map[string]interface{}{"Text": kintone.SingleLineTextField("b")})
+	bulkReq.SetSource(second, &BulkRequestSource{
+		Row:     3,
+		Lines:   []uint64{4, 5, 7},
+		Rows:    [][]string{{"*", "b"}, {"", ""}, {"", "c"}},
+		Columns: columns,
+		SubRows: map[string][]int{"Table": {0, 2}},
+	})
+	bulkReq.ImportDataInsert(app, second)
+
+	tests := map[string]string{
+		"records[0].Text.value":                             "line 2, column `Text` (col 2)",
+		"records[1].price.value":                            "line 4, column `price` (col 7)",
+		"records[1].Table.value[1].value.Table_price.value": "line 7, column `Table_price` (col 9)",
+		"records[1].updateKey.value":                        "line 4, field `updateKey`",
+		"requests[0].payload.records[0].price.value":        "line 2, column `price` (col 7)",
+		"records[2].price.value":                            "",
+		"unknown":                                           "",
+	}
+	for key, expected := range tests {
+		if location := bulkReq.LocateError(0, key); location != expected {
+			t.Errorf("LocateError(%q) must be %q but %q", key, expected, location)
+		}
+	}
+
+	source := bulkReq.Sources[second]
+	if source.Position() != "lines 4 - 7" {
+		t.Error("Invalid position:", source.Position())
+	}
+	if (&BulkRequestSource{Row: 5}).Position() != "record 5" {
+		t.Error("Position without lines must be the record number")
+	}
+	if first, last := bulkReq.Lines(); first != 2 || last != 7 {
+		t.Errorf("Lines must be 2 - 7 but %d - %d", first, last)
+	}
+	bulkReq.SetSource(kintone.NewRecord(nil), &BulkRequestSource{Row: 4})
+	if first, last := bulkReq.Lines(); first != 0 || last != 0 {
+		t.Errorf("Lines must be unknown but %d - %d", first, last)
+	}
+}
@@ -2,24 +2,15 @@ package main
 
 import (
 	"fmt"
-	"testing"
-	"os"
 	"github.com/kintone-labs/go-kintone"
+	"strconv"
+	"testing"
 )
 
-func newAppTest(id uint64) *kintone.App {
-	return &kintone.App{
-		Domain:   os.Getenv("KINTONE_DOMAIN"),
-		User:     os.Getenv("KINTONE_USERNAME"),
-		Password: os.Getenv("KINTONE_PASSWORD"),
-		AppId:    id,
-	}
-}
-
 func TestRequest(t *testing.T) {
 
 	bulkReq := &BulkRequests{}
-	app := newAppTest(16)
+	app := newApp(t)
 	bulkReq.Requests = make([]*BulkRequestItem, 0)
 
 	/// INSERT
@@ -39,17 +30,28 @@ func TestRequest(t *testing.T) {
 
 	bulkReq.Requests = append(bulkReq.Requests, postRecords)
 
+	rs, err := bulkReq.Request(app)
+	if err != nil {
+		t.Fatal(" failed", err)
+	}
+	ids := rs.Results[0].(map[string]interface{})["ids"].([]interface{})
+	if len(ids) != 2 {
+		t.Fatalf("2 records must be inserted but %v", rs.Results)
+	}
+
 	/// UPDATE
+	bulkReq.Requests = make([]*BulkRequestItem, 0)
 	recordsUpdate := make([]interface{}, 0)
-	recordsUpdate1 := kintone.NewRecordWithId(4902, map[string]interface{}{
+	id1, _ := strconv.ParseUint(ids[0].(string), 10, 64)
+	recordsUpdate1 := kintone.NewRecordWithId(id1, map[string]interface{}{
 		"Text": kintone.SingleLineTextField("test NNN!"),
 		"_2":   kintone.SingleLineTextField("test MMM!"),
 	})
-	fmt.Println(recordsUpdate1)
 	recordsUpdate = append(recordsUpdate, &DataRequestRecordPUT{ID: recordsUpdate1.Id(),
 		Record: recordsUpdate1})
 
-	recordsUpdate2 := kintone.NewRecordWithId(4903, map[string]interface{}{
+	id2, _ := strconv.ParseUint(ids[1].(string), 10, 64)
+	recordsUpdate2 := kintone.NewRecordWithId(id2, map[string]interface{}{
 		"Text": kintone.SingleLineTextField("test 123!"),
 		"_2":   kintone.SingleLineTextField("test 234!"),
 	})
@@ -61,11 +63,145 @@ func TestRequest(t *testing.T) {
 
 	bulkReq.Requests = append(bulkReq.Requests, putRecords)
 
-	rs, err := bulkReq.Request(app)
+	rs, err = bulkReq.Request(app)
 
 	if err != nil {
 		t.Error(" failed", err)
 	} else {
 		t.Log(rs)
 	}
+
+	recs, err := app.GetRecords([]string{"Text", "_2"}, fmt.Sprintf("$id in (%d, %d) order by $id asc", id1, id2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 2 || recs[0].Fields["Text"] != kintone.SingleLineTextField("test NNN!") || recs[1].Fields["_2"] != kintone.SingleLineTextField("test 234!") {
+		t.Errorf("the records must be updated: %v", recs)
+	}
+}
+
+func TestRequestError(t *testing.T) {
+	app := newApp(t)
+
+	// the update of a missing record fails, and the insert is rolled back
+	bulkReq := &BulkRequests{}
+	bulkReq.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{
+		"Text": kintone.SingleLineTextField("insert"),
+	}))
+	bulkReq.ImportDataUpdate(app, kintone.NewRecordWithId(100, map[string]interface{}{
+		"Text": kintone.SingleLineTextField("update"),
+	}), "")
+	_, err := bulkReq.Request(app)
+	errs, ok := err.(*BulkRequestsErrors)
+	if !ok || len(errs.Results) != 2 || errs.Results[1].Code != "GAIA_RE01" {
+		t.Fatalf("the bulkRequest must fail with GAIA_RE01 but %#v", err)
+	}
+	recs, err := app.GetRecords(nil, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Errorf("the insert must be rolled back but %d records", len(recs))
+	}
+
+	// the invalid number
+	bulkReq = &BulkRequests{}
+	bulkReq.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{
+		"number": kintone.DecimalField("abc"),
+	}))
+	_, err = bulkReq.Request(app)
+	code, message := bulkReq.getErrorDetail(err)
+	if code != "CB_VA01" || message != "Missing or invalid input. 'records[0].number.value': Only numbers are allowed." {
+		t.Errorf("Invalid error: %s %s", code, message)
+	}
+}
+
+func TestCountRecords(t *testing.T) {
+	bulkReq := &BulkRequests{}
+	app := &kintone.App{AppId: 1}
+
+	for i := 0; i < 150; i++ {
+		bulkReq.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{
+			"Text": kintone.SingleLineTextField("insert"),
+		}))
+	}
+	for i := 0; i < 3; i++ {
+		bulkReq.ImportDataUpdate(app, kintone.NewRecordWithId(uint64(i+1), map[string]interface{}{
+			"Text": kintone.SingleLineTextField("update"),
+		}), "")
+	}
+
+	inserted, updated := bulkReq.CountRecords()
+	if inserted != 150 || updated != 3 {
+		t.Errorf("CountRecords must be (150, 3) but (%d, %d)", inserted, updated)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	bulkReq := &BulkRequests{}
+	app := &kintone.App{AppId: 1}
+
+	insert := kintone.NewRecord(map[string]interface{}{"Text": kintone.SingleLineTextField("insert")})
+	bulkReq.SetSource(insert, &BulkRequestSource{Row: 1})
+	bulkReq.ImportDataInsert(app, insert)
+	update := kintone.NewRecordWithId(10, map[string]interface{}{"Text": kintone.SingleLineTextField("update")})
+	bulkReq.SetSource(update, &BulkRequestSource{Row: 2})
+	bulkReq.ImportDataUpdate(app, update, "")
+
+	singles := bulkReq.Split()
+	if len(singles) != 2 {
+		t.Fatalf("Split must return 2 bulkRequests but %d", len(singles))
+	}
+	for i, expected := range []uint64{1, 2} {
+		if len(singles[i].Requests) != 1 || len(singles[i].Sources) != 1 {
+			t.Fatalf("bulkRequest[%d] must have only one record", i)
+		}
+		for _, source := range singles[i].Sources {
+			if source.Row != expected {
+				t.Errorf("bulkRequest[%d] must have the record of row %d", i, expected)
+			}
+		}
+	}
+}
+
+func TestGetErrorDetail(t *testing.T) {
+	err := &BulkRequestsErrors{Results: []*BulkRequestsError{
+		{},
+		{Code: "CB_VA01", Message: "Invalid input.", Errors: map[string]interface{}{
+			"records[0].number.value": map[string]interface{}{"messages": []interface{}{"Only numbers are allowed."}},
+		}},
+	}}
+	code, message := (&BulkRequests{}).getErrorDetail(err)
+	if code != "CB_VA01" {
+		t.Error("Invalid error code:", code)
+	}
+	if message != "Invalid input. 'records[0].number.value': Only numbers are allowed." {
+		t.Error("Invalid error message:", message)
+	}
+}
+
+func TestIsFull(t *testing.T) {
+	app := &kintone.App{AppId: 1}
+	bulk := &BulkRequests{}
+	for i := 0; i < 150; i++ {
+		bulk.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{}))
+	}
+	if bulk.IsFull(2000) {
+		t.Error("a bulkRequest of 150 records must not be full")
+	}
+	if !bulk.IsFull(150) {
+		t.Error("a bulkRequest of 150 records must be full with the limit 150")
+	}
+
+	// 19 requests of inserts and 1 request of updates
+	for i := 150; i < 1900; i++ {
+		bulk.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{}))
+	}
+	bulk.ImportDataUpdate(app, kintone.NewRecordWithId(1, map[string]interface{}{}), "")
+	if len(bulk.Requests) != ConstBulkRequestLimitRequest {
+		t.Fatalf("the bulkRequest must have %d requests but %d", ConstBulkRequestLimitRequest, len(bulk.Requests))
+	}
+	if !bulk.IsFull(2000) {
+		t.Error("a bulkRequest must be full when no insert can be added")
+	}
 }
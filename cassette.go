@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// REDACTED the value of the redacted headers in the cassette
+const REDACTED = "REDACTED"
+
+// REDACTED_HEADERS the headers with the credentials, which are not written into the cassette
+var REDACTED_HEADERS = []string{
+	"Authorization",
+	"Proxy-Authorization",
+	"X-Cybozu-Authorization",
+	"X-Cybozu-API-Token",
+	"Cookie",
+	"Set-Cookie",
+}
+
+// Interaction a request and its response in the cassette of "--record-http"
+type Interaction struct {
+	Request  RecordedRequest   `json:"request"`
+	Response *RecordedResponse `json:"response,omitempty"`
+	// the error of the request, without the response
+	Error string `json:"error,omitempty"`
+}
+
+// RecordedRequest a request in the cassette
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header"`
+	RecordedBody
+}
+
+// RecordedResponse a response in the cassette
+type RecordedResponse struct {
+	Status     string      `json:"status"`
+	StatusCode int         `json:"statusCode"`
+	Header     http.Header `json:"header"`
+	RecordedBody
+}
+
+// RecordedBody the body as a string, or in base64 if it is not a text
+type RecordedBody struct {
+	Body         string `json:"body"`
+	BodyEncoding string `json:"bodyEncoding,omitempty"`
+}
+
+func newRecordedBody(data []byte) RecordedBody {
+	if utf8.Valid(data) {
+		return RecordedBody{Body: string(data)}
+	}
+	return RecordedBody{Body: base64.StdEncoding.EncodeToString(data), BodyEncoding: "base64"}
+}
+
+func (body RecordedBody) bytes() ([]byte, error) {
+	if body.BodyEncoding == "base64" {
+		return base64.StdEncoding.DecodeString(body.Body)
+	}
+	return []byte(body.Body), nil
+}
+
+// redactHeader a copy of the header without the credentials
+func redactHeader(header http.Header) http.Header {
+	redacted := header.Clone()
+	if redacted == nil {
+		redacted = http.Header{}
+	}
+	for _, key := range REDACTED_HEADERS {
+		if _, ok := redacted[http.CanonicalHeaderKey(key)]; ok {
+			redacted.Set(key, REDACTED)
+		}
+	}
+	return redacted
+}
+
+// RecordTransport writes the requests and their responses into the directory of "--record-http",
+// a file for each request in the order of the requests. The credentials in the headers are redacted.
+type RecordTransport struct {
+	Transport http.RoundTripper
+	Dir       string
+
+	mutex sync.Mutex
+	count int
+}
+
+// newRecordTransport the transport recording into the directory, which must be new or empty
+func newRecordTransport(transport http.RoundTripper, dir string) (*RecordTransport, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		return nil, fmt.Errorf("The directory %s of the --record-http option is not empty.", dir)
+	}
+	return &RecordTransport{Transport: transport, Dir: dir}, nil
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RecordTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		req = req.Clone(req.Context())
+		req.Body = ioutil.NopCloser(bytes.NewReader(body))
+		req.GetBody = func() (io.ReadCloser, error) {
+			return ioutil.NopCloser(bytes.NewReader(body)), nil
+		}
+	}
+	u := *req.URL
+	u.User = nil
+	interaction := &Interaction{Request: RecordedRequest{
+		Method:       req.Method,
+		URL:          u.String(),
+		Header:       redactHeader(req.Header),
+		RecordedBody: newRecordedBody(body),
+	}}
+
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		interaction.Error = err.Error()
+		if errRecord := t.record(interaction); errRecord != nil {
+			return nil, errRecord
+		}
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+	interaction.Response = &RecordedResponse{
+		Status:       resp.Status,
+		StatusCode:   resp.StatusCode,
+		Header:       redactHeader(resp.Header),
+		RecordedBody: newRecordedBody(data),
+	}
+	if err := t.record(interaction); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+// record writes the interaction into "<number>-<method>-<api>.json"
+func (t *RecordTransport) record(interaction *Interaction) error {
+	data, err := json.MarshalIndent(interaction, "", "  ")
+	if err != nil {
+		return err
+	}
+	t.mutex.Lock()
+	t.count++
+	count := t.count
+	t.mutex.Unlock()
+
+	// "/k/v1/records/cursor.json" => "records-cursor"
+	api := interaction.Request.URL
+	if u, err := url.Parse(api); err == nil {
+		api = u.Path
+	}
+	if index := strings.Index(api, "/v1/"); index >= 0 {
+		api = api[index+len("/v1/"):]
+	}
+	api = strings.Replace(strings.TrimSuffix(api, ".json"), "/", "-", -1)
+	name := fmt.Sprintf("%05d-%s-%s.json", count, interaction.Request.Method, api)
+	return ioutil.WriteFile(filepath.Join(t.Dir, name), data, 0600)
+}
+
+// ReplayTransport returns the responses recorded by "--record-http" instead of sending the requests.
+// A request gets the first response not returned yet of the same method and URL, with the same body if any.
+type ReplayTransport struct {
+	Dir          string
+	mutex        sync.Mutex
+	interactions []*Interaction
+	replayed     []bool
+}
+
+// newReplayTransport loads the cassette of the directory
+func newReplayTransport(dir string) (*ReplayTransport, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("No request is recorded in the directory %s of the --replay-http option.", dir)
+	}
+	sort.Strings(files)
+	t := &ReplayTransport{Dir: dir}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var interaction Interaction
+		if err := json.Unmarshal(data, &interaction); err != nil {
+			return nil, fmt.Errorf("%s: %v", file, err)
+		}
+		t.interactions = append(t.interactions, &interaction)
+	}
+	t.replayed = make([]bool, len(t.interactions))
+	return t, nil
+}
+
+// requestKey the method and the URL of the request without the scheme and the host,
+// which are those of "-d" or "--base-url"
+func requestKey(method string, u *url.URL) string {
+	return method + " " + u.RequestURI()
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *ReplayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil && req.Body != http.NoBody {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	key := requestKey(req.Method, req.URL)
+
+	t.mutex.Lock()
+	found := -1
+	for i, interaction := range t.interactions {
+		if t.replayed[i] {
+			continue
+		}
+		u, err := url.Parse(interaction.Request.URL)
+		if err != nil || requestKey(interaction.Request.Method, u) != key {
+			continue
+		}
+		if found < 0 {
+			found = i
+		}
+		// the bodies of the multipart requests differ by their boundaries
+		if recorded, _ := interaction.Request.bytes(); bytes.Equal(recorded, body) {
+			found = i
+			break
+		}
+	}
+	if found >= 0 {
+		t.replayed[found] = true
+	}
+	t.mutex.Unlock()
+
+	if found < 0 {
+		return nil, fmt.Errorf("The request %s is not recorded in the directory %s of the --replay-http option.", key, t.Dir)
+	}
+	interaction := t.interactions[found]
+	if interaction.Response == nil {
+		return nil, errors.New(interaction.Error)
+	}
+	data, err := interaction.Response.bytes()
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        interaction.Response.Status,
+		StatusCode:    interaction.Response.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        interaction.Response.Header.Clone(),
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cassette")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	fake := newFakeKintone(t, newTestApp())
+	data := "Text,number\naaa,1\nbbb,2\n"
+
+	run := func() error {
+		resetTransport()
+		if err := checkOptions(); err != nil {
+			t.Fatal(err)
+		}
+		app, err := makeApp()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return importFromCSV(app, bytes.NewBufferString(data), nil)
+	}
+
+	config = Configure{}
+	config.BaseURL = fake.server.URL
+	config.AppID = TEST_APP_ID
+	config.APIToken = []string{"test-api-token"}
+	config.RecordHTTP = dir
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	requests := len(fake.Requests)
+
+	files, _ := filepath.Glob(filepath.Join(dir, "*.json"))
+	if len(files) != requests {
+		t.Fatalf("%d requests must be recorded but %d files", requests, len(files))
+	}
+	for _, file := range files {
+		content, _ := ioutil.ReadFile(file)
+		if strings.Contains(string(content), "test-api-token") || !strings.Contains(string(content), REDACTED) {
+			t.Errorf("the API token must be redacted in %s", file)
+		}
+	}
+	if !strings.HasSuffix(files[len(files)-1], "-POST-bulkRequest.json") {
+		t.Errorf("the last request must be the bulkRequest: %s", files[len(files)-1])
+	}
+
+	// no credential and no request to kintone
+	config = Configure{}
+	config.AppID = TEST_APP_ID
+	config.ReplayHTTP = dir
+	if !hasConnectionOptions() {
+		t.Error("the credentials must not be required to replay")
+	}
+	if err := run(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Requests) != requests || len(fake.Records(TEST_APP_ID)) != 2 {
+		t.Errorf("the requests must not be sent when they are replayed")
+	}
+
+	// the request which is not recorded, or replayed already
+	replay, err := newReplayTransport(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: replay}
+	if _, err := client.Get("https://example.cybozu.com/k/v1/records.json?app=1"); err == nil || !strings.Contains(err.Error(), "is not recorded") {
+		t.Errorf("the request which is not recorded must be an error: %v", err)
+	}
+	app := fake.App(TEST_APP_ID)
+	app.Client = client
+	if _, err := app.Fields(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := app.Fields(); err == nil {
+		t.Error("a response must be replayed only once")
+	}
+
+	config = Configure{}
+	config.RecordHTTP = dir
+	resetTransport()
+	if _, err := getTransport(); err == nil {
+		t.Error("the directory of --record-http must be empty")
+	}
+	config.ReplayHTTP = dir
+	if err := checkOptions(); err == nil {
+		t.Error("--record-http and --replay-http must be an error together")
+	}
+}
@@ -0,0 +1,320 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+)
+
+// ExportCheckpoint progress of an export, saved to the checkpoint file after each page of records.
+// A nil ExportCheckpoint saves nothing.
+type ExportCheckpoint struct {
+	path         string
+	output       *os.File
+	writer       io.Writer
+	AppID        uint64   `json:"app"`
+	Query        string   `json:"query"`
+	Fields       []string `json:"fields"`
+	Format       string   `json:"format"`
+	Encoding     string   `json:"encoding"`
+	LastID       uint64   `json:"lastId"`       // $id of the last record written by the seek method
+	Records      uint64   `json:"records"`      // number of the records written
+	OutputSize   int64    `json:"outputSize"`   // size of the output of "--output" at the checkpoint
+	ManifestSize int64    `json:"manifestSize"` // size of the manifest of the attachment files
+}
+
+// writeJSONFile writes the data to a temporary file and renames it, so that the file is never half written
+func writeJSONFile(path string, data interface{}) error {
+	b, err := json.MarshalIndent(data, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// getOutputSize the size of the output written so far, -1 if the output is not a file
+func getOutputSize(output *os.File) int64 {
+	info, err := output.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return -1
+	}
+	return info.Size()
+}
+
+// loadExportCheckpoint loads the checkpoint file of "--checkpoint", or starts a new one.
+// When an export is resumed, the output of "--output" written after the checkpoint is truncated.
+// writer is the writer of the records to output, flushed before the size of the output is saved.
+func loadExportCheckpoint(output *os.File, writer io.Writer, fields []string) (*ExportCheckpoint, error) {
+	if config.Checkpoint == "" {
+		return nil, nil
+	}
+	if getOutputSize(output) < 0 {
+		return nil, fmt.Errorf("The output of the --checkpoint option must be a regular file given with the --output option.")
+	}
+	checkpoint := &ExportCheckpoint{
+		path:       config.Checkpoint,
+		output:     output,
+		writer:     writer,
+		AppID:      config.AppID,
+		Query:      config.Query,
+		Fields:     fields,
+		Format:     config.Format,
+		Encoding:   config.Encoding,
+		OutputSize: -1,
+	}
+
+	data, err := ioutil.ReadFile(config.Checkpoint)
+	if os.IsNotExist(err) {
+		if err := resetManifest(0); err != nil {
+			return nil, err
+		}
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved ExportCheckpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("The checkpoint file %s is broken: %v", config.Checkpoint, err)
+	}
+	if saved.AppID != checkpoint.AppID || saved.Query != checkpoint.Query || !reflect.DeepEqual(saved.Fields, checkpoint.Fields) ||
+		saved.Format != checkpoint.Format || saved.Encoding != checkpoint.Encoding {
+		return nil, fmt.Errorf("The checkpoint file %s was saved by an export with different options", config.Checkpoint)
+	}
+
+	size := getOutputSize(output)
+	if size < saved.OutputSize {
+		return nil, fmt.Errorf("The output is shorter than the checkpoint (%d < %d bytes). Specify the file of the interrupted export with the --output option", size, saved.OutputSize)
+	}
+	if size > saved.OutputSize {
+		// remove the records written after the checkpoint
+		if err := output.Truncate(saved.OutputSize); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := output.Seek(0, io.SeekEnd); err != nil {
+		return nil, err
+	}
+	if err := resetManifest(saved.ManifestSize); err != nil {
+		return nil, err
+	}
+	checkpoint.LastID = saved.LastID
+	checkpoint.Records = saved.Records
+	checkpoint.OutputSize = saved.OutputSize
+	checkpoint.ManifestSize = saved.ManifestSize
+	return checkpoint, nil
+}
+
+// Start the $id of the last record and the number of the records written before the checkpoint
+func (checkpoint *ExportCheckpoint) Start() (uint64, uint64) {
+	if checkpoint == nil {
+		return 0, 0
+	}
+	return checkpoint.LastID, checkpoint.Records
+}
+
+// Save saves the progress after the records up to lastID were written
+func (checkpoint *ExportCheckpoint) Save(lastID, records uint64) error {
+	if checkpoint == nil {
+		return nil
+	}
+	// the files of the records written must be downloaded before
+	if err := waitDownloads(); err != nil {
+		return err
+	}
+	// the size is read after the encoder of "-e" wrote all the records
+	if err := flushWriter(checkpoint.writer); err != nil {
+		return err
+	}
+	checkpoint.LastID = lastID
+	checkpoint.Records = records
+	checkpoint.OutputSize = getOutputSize(checkpoint.output)
+	checkpoint.ManifestSize = getManifestSize()
+	return writeJSONFile(checkpoint.path, checkpoint)
+}
+
+// Done removes the checkpoint file when the export is completed
+func (checkpoint *ExportCheckpoint) Done() error {
+	if checkpoint == nil {
+		return nil
+	}
+	err := os.Remove(checkpoint.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// ImportCheckpoint progress of an import, saved to the checkpoint file after each committed bulkRequest.
+// A nil ImportCheckpoint saves nothing.
+type ImportCheckpoint struct {
+	path     string
+	AppID    uint64 `json:"app"`
+	File     string `json:"file"`
+	SHA256   string `json:"sha256"`
+	Encoding string `json:"encoding"`
+	Row      uint64 `json:"row"`    // position of the last committed record (the value of "-l")
+	Line     uint64 `json:"line"`   // line where the last committed record ends
+	Offset   int64  `json:"offset"` // bytes of the decoded input read up to the end of the last committed record
+	// records committed after Row, by bulkRequests sent at the same time as the ones which were not committed
+	Committed []ImportRange `json:"committed,omitempty"`
+	resumed   []ImportRange
+}
+
+// ImportRange positions of the records from First to Last
+type ImportRange struct {
+	First uint64 `json:"first"`
+	Last  uint64 `json:"last"`
+}
+
+// countReader counts the bytes read
+type countReader struct {
+	reader io.Reader
+	count  int64
+}
+
+func (r *countReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	r.count += int64(n)
+	return n, err
+}
+
+func getFileHash(file *os.File) (string, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// loadImportCheckpoint loads the checkpoint file of "--checkpoint", or starts a new one.
+// The import of a file changed after the checkpoint was saved is refused.
+func loadImportCheckpoint(file *os.File) (*ImportCheckpoint, error) {
+	if config.Checkpoint == "" {
+		return nil, nil
+	}
+	hash, err := getFileHash(file)
+	if err != nil {
+		return nil, err
+	}
+	checkpoint := &ImportCheckpoint{
+		path:     config.Checkpoint,
+		AppID:    config.AppID,
+		File:     file.Name(),
+		SHA256:   hash,
+		Encoding: config.Encoding,
+	}
+
+	data, err := ioutil.ReadFile(config.Checkpoint)
+	if os.IsNotExist(err) {
+		return checkpoint, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var saved ImportCheckpoint
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return nil, fmt.Errorf("The checkpoint file %s is broken: %v", config.Checkpoint, err)
+	}
+	if saved.AppID != checkpoint.AppID || saved.Encoding != checkpoint.Encoding {
+		return nil, fmt.Errorf("The checkpoint file %s was saved by an import with different options", config.Checkpoint)
+	}
+	if saved.SHA256 != checkpoint.SHA256 {
+		return nil, fmt.Errorf("The input file was changed after the checkpoint file %s was saved. Remove the checkpoint file to import the whole file", config.Checkpoint)
+	}
+
+	checkpoint.Row = saved.Row
+	checkpoint.Line = saved.Line
+	checkpoint.Offset = saved.Offset
+	checkpoint.Committed = saved.Committed
+	checkpoint.resumed = append([]ImportRange{}, saved.Committed...)
+	if checkpoint.Row > 0 || len(checkpoint.Committed) > 0 {
+		showTimeLog()
+		fmt.Printf("Resume the import after the record %d (line %d) committed before\n", checkpoint.Row, checkpoint.Line)
+		// the records were already deleted by the interrupted import
+		config.DeleteAll = false
+	}
+	return checkpoint, nil
+}
+
+// Start the position, the line and the offset of the last record committed before the checkpoint
+func (checkpoint *ImportCheckpoint) Start() (uint64, uint64, int64) {
+	if checkpoint == nil {
+		return 0, 0, 0
+	}
+	return checkpoint.Row, checkpoint.Line, checkpoint.Offset
+}
+
+// IsCommitted reports whether the record at row was committed after the position of the checkpoint
+func (checkpoint *ImportCheckpoint) IsCommitted(row uint64) bool {
+	if checkpoint == nil {
+		return false
+	}
+	for _, committed := range checkpoint.resumed {
+		if committed.First <= row && row <= committed.Last {
+			return true
+		}
+	}
+	return false
+}
+
+// Commit saves the records from first to last committed before the previous records
+func (checkpoint *ImportCheckpoint) Commit(first, last uint64) error {
+	if checkpoint == nil || config.DryRun {
+		return nil
+	}
+	checkpoint.Committed = append(checkpoint.Committed, ImportRange{First: first, Last: last})
+	return writeJSONFile(checkpoint.path, checkpoint)
+}
+
+// Save saves the progress after the records up to row were committed
+func (checkpoint *ImportCheckpoint) Save(row, line uint64, offset int64) error {
+	if checkpoint == nil || config.DryRun {
+		return nil
+	}
+	checkpoint.Row = row
+	checkpoint.Line = line
+	checkpoint.Offset = offset
+	committed := checkpoint.Committed[:0]
+	for _, r := range checkpoint.Committed {
+		if r.Last > row {
+			committed = append(committed, r)
+		}
+	}
+	checkpoint.Committed = committed
+	return writeJSONFile(checkpoint.path, checkpoint)
+}
+
+// Done removes the checkpoint file when the whole file was imported
+func (checkpoint *ImportCheckpoint) Done() error {
+	if checkpoint == nil || config.DryRun {
+		return nil
+	}
+	err := os.Remove(checkpoint.path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
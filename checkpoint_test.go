@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/text/encoding/japanese"
+)
+
+func TestExportCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+	config.Checkpoint = filepath.Join(dir, "export.checkpoint")
+	config.AppID = 1
+	config.Format = "csv"
+
+	output, err := os.Create(filepath.Join(dir, "output.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+
+	checkpoint, err := loadExportCheckpoint(output, output, []string{"$id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, records := checkpoint.Start(); id != 0 || records != 0 {
+		t.Fatalf("a new export must start from the beginning but %d, %d", id, records)
+	}
+	output.WriteString("header\nrecord 10\n")
+	if err := checkpoint.Save(10, 1); err != nil {
+		t.Fatal(err)
+	}
+	// interrupted while writing the next page
+	output.WriteString("record 9\nrec")
+
+	checkpoint, err = loadExportCheckpoint(output, output, []string{"$id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, records := checkpoint.Start(); id != 10 || records != 1 {
+		t.Errorf("the export must be resumed after $id 10 but %d, %d", id, records)
+	}
+	data, _ := ioutil.ReadFile(output.Name())
+	if string(data) != "header\nrecord 10\n" {
+		t.Errorf("the output after the checkpoint must be truncated but %q", string(data))
+	}
+
+	if _, err := loadExportCheckpoint(output, output, []string{"$id", "name"}); err == nil {
+		t.Error("the checkpoint of an export with different fields must be refused")
+	}
+
+	if err := checkpoint.Done(); err != nil {
+		t.Fatal(err)
+	}
+	if isExistFile(config.Checkpoint) {
+		t.Error("the checkpoint file must be removed when the export is completed")
+	}
+}
+
+func TestExportCheckpointEncoding(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+	config.Checkpoint = filepath.Join(dir, "export.checkpoint")
+	config.Encoding = "sjis"
+
+	output, err := os.Create(filepath.Join(dir, "output.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	writer := getWriter(output)
+	checkpoint, err := loadExportCheckpoint(output, writer, []string{"$id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a character cut by the writes
+	record := []byte("記録\r\n")
+	writer.Write(record[:4])
+	writer.Write(record[4:])
+	if err := checkpoint.Save(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	encoded, _ := japanese.ShiftJIS.NewEncoder().Bytes(record)
+	if checkpoint.OutputSize != int64(len(encoded)) {
+		t.Errorf("the size of the output must be %d but %d", len(encoded), checkpoint.OutputSize)
+	}
+
+	writer.Write(record[:1])
+	if err := checkpoint.Save(2, 2); err == nil {
+		t.Error("the output which ends in the middle of a character must not be saved")
+	}
+}
+
+func TestOpenExportOutput(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+	config = Configure{}
+	config.Checkpoint = filepath.Join(dir, "export.checkpoint")
+	config.AppID = 1
+	config.Format = "csv"
+
+	if _, err := openExportOutput(); err == nil {
+		t.Error("--checkpoint without --output must be an error")
+	}
+	config.Output = filepath.Join(dir, "output.csv")
+	config.Query = "number > 1"
+	if _, err := openExportOutput(); err == nil {
+		t.Error("--checkpoint with -q must be an error")
+	}
+	config.Query = ""
+
+	ioutil.WriteFile(config.Output, []byte("old export\n"), 0644)
+	output, err := openExportOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := loadExportCheckpoint(output, output, []string{"$id"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	output.WriteString("header\n")
+	if err := checkpoint.Save(10, 1); err != nil {
+		t.Fatal(err)
+	}
+	output.WriteString("record 9\n")
+	output.Close()
+	data, _ := ioutil.ReadFile(config.Output)
+	if string(data) != "header\nrecord 9\n" {
+		t.Errorf("the output of a new export must be truncated but %q", string(data))
+	}
+
+	// resumed: the output is kept up to the checkpoint, and written after it
+	output, err = openExportOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if _, err := loadExportCheckpoint(output, output, []string{"$id"}); err != nil {
+		t.Fatal(err)
+	}
+	output.WriteString("record 8\n")
+	data, _ = ioutil.ReadFile(config.Output)
+	if string(data) != "header\nrecord 8\n" {
+		t.Errorf("the export must be resumed after the checkpoint but %q", string(data))
+	}
+
+	// a pipe is never truncated
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	defer writer.Close()
+	if _, err := loadExportCheckpoint(writer, writer, []string{"$id"}); err == nil {
+		t.Error("the checkpoint of an output which is not a regular file must be refused")
+	}
+}
+
+func TestImportCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "checkpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+	config.Checkpoint = filepath.Join(dir, "import.checkpoint")
+	config.AppID = 1
+	config.DeleteAll = true
+
+	path := filepath.Join(dir, "input.csv")
+	ioutil.WriteFile(path, []byte("name\nJohn\nJane\n"), 0644)
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, err := loadImportCheckpoint(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row, _, _ := checkpoint.Start(); row != 0 {
+		t.Fatalf("a new import must start from the beginning but %d", row)
+	}
+	if err := checkpoint.Save(2, 2, 10); err != nil {
+		t.Fatal(err)
+	}
+
+	file, _ = os.Open(path)
+	checkpoint, err = loadImportCheckpoint(file)
+	file.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if row, line, offset := checkpoint.Start(); row != 2 || line != 2 || offset != 10 {
+		t.Errorf("the import must be resumed after the record 2 but %d, %d, %d", row, line, offset)
+	}
+	if config.DeleteAll {
+		t.Error("the records must not be deleted again when the import is resumed")
+	}
+
+	ioutil.WriteFile(path, []byte("name\nJohn\nJim\n"), 0644)
+	file, _ = os.Open(path)
+	_, err = loadImportCheckpoint(file)
+	file.Close()
+	if err == nil {
+		t.Error("the checkpoint of a changed file must be refused")
+	}
+}
+
+func TestCountReaderOffset(t *testing.T) {
+	input := &countReader{reader: strings.NewReader("name\n\"a\nb\"\nc\n")}
+	buffered := bufio.NewReader(input)
+	reader := csv.NewReader(buffered)
+	expected := []int64{5, 11, 13}
+	for i, offset := range expected {
+		if _, err := reader.Read(); err != nil {
+			t.Fatal(err)
+		}
+		if actual := input.count - int64(buffered.Buffered()); actual != offset {
+			t.Errorf("offset of row %d must be %d but %d", i, offset, actual)
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// SECURE_ACCESS_DOMAINS the domains of kintone whose secure access domain is "<subdomain>.s.<domain>"
+var SECURE_ACCESS_DOMAINS = []string{"cybozu.com", "kintone.com", "cybozu.cn"}
+
+var (
+	sharedTransport     http.RoundTripper
+	sharedTransportErr  error
+	sharedTransportOnce sync.Once
+)
+
+// BaseURLTransport sends the requests to "https://<domain>" to the URL of "--base-url" instead,
+// with its scheme, host, port and path prefix
+type BaseURLTransport struct {
+	Transport http.RoundTripper
+	BaseURL   *url.URL
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *BaseURLTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	u := *req.URL
+	u.Scheme = t.BaseURL.Scheme
+	u.Host = t.BaseURL.Host
+	u.Path = strings.TrimSuffix(t.BaseURL.Path, "/") + req.URL.Path
+	u.RawPath = ""
+	r := req.Clone(req.Context())
+	r.URL = &u
+	r.Host = u.Host
+	return t.Transport.RoundTrip(r)
+}
+
+// parseBaseURL the URL of "--base-url"
+func parseBaseURL(baseURL string) (*url.URL, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid base URL %s: %v", baseURL, err)
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("Invalid base URL %s: specify the scheme and the host like https://example.cybozu.com", baseURL)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("Invalid base URL %s: the query and the fragment are not supported", baseURL)
+	}
+	return u, nil
+}
+
+// newHTTPClient the client shared by go-kintone and the bulkRequest
+func newHTTPClient() (*http.Client, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	base, err := getTransport()
+	if err != nil {
+		return nil, err
+	}
+	transport := &RetryTransport{Transport: base, Policy: newRetryPolicy()}
+	return &http.Client{Jar: jar, Transport: transport}, nil
+}
+
+// getTransport the transport of all the requests, created once from the options.
+// It records the requests with "--record-http", or replays them with "--replay-http".
+func getTransport() (http.RoundTripper, error) {
+	sharedTransportOnce.Do(func() {
+		if config.ReplayHTTP != "" {
+			// no request is sent
+			sharedTransport, sharedTransportErr = newReplayTransport(config.ReplayHTTP)
+			return
+		}
+		var transport *http.Transport
+		transport, sharedTransportErr = newTransport()
+		if sharedTransportErr != nil {
+			return
+		}
+		sharedTransport = transport
+		if config.BaseURL != "" {
+			var baseURL *url.URL
+			if baseURL, sharedTransportErr = parseBaseURL(config.BaseURL); sharedTransportErr != nil {
+				return
+			}
+			sharedTransport = &BaseURLTransport{Transport: transport, BaseURL: baseURL}
+		}
+		if config.RecordHTTP != "" {
+			sharedTransport, sharedTransportErr = newRecordTransport(sharedTransport, config.RecordHTTP)
+		}
+	})
+	return sharedTransport, sharedTransportErr
+}
+
+// newTransport the transport of the requests, with the proxy of "--proxy" or of the environment variables,
+// the CA certificates of "--ca-cert" and the client certificate of "--client-cert"
+func newTransport() (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if config.Proxy != "" {
+		proxy, err := parseProxyURL(config.Proxy)
+		if err != nil {
+			return nil, err
+		}
+		noProxy := getEnv("NO_PROXY", "no_proxy")
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if isNoProxy(req.URL.Host, noProxy) {
+				return nil, nil
+			}
+			return proxy, nil
+		}
+	}
+
+	tlsConfig := &tls.Config{}
+	if config.CACert != "" {
+		pool, err := loadCACertificates(config.CACert)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if config.ClientCert != "" {
+		cert, err := loadClientCertificate(config.ClientCert, config.ClientKey, config.ClientCertPass)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	transport.TLSClientConfig = tlsConfig
+	return transport, nil
+}
+
+// parseProxyURL the URL of "--proxy", "http://" is added if it has no scheme.
+// The user and the password in the URL are sent to the proxy.
+func parseProxyURL(proxy string) (*url.URL, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// the error would show the password
+		return nil, fmt.Errorf("The --proxy option is not a valid URL.")
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("Invalid proxy %s: the scheme must be http, https or socks5", u.Redacted())
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid proxy %s: the host is empty", u.Redacted())
+	}
+	return u, nil
+}
+
+// getEnv the value of the first environment variable which is set
+func getEnv(keys ...string) string {
+	for _, key := range keys {
+		if value := os.Getenv(key); value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// isNoProxy reports whether the host is in the NO_PROXY list: "*", host names (with their subdomains),
+// ".domain" suffixes, IP addresses, CIDR ranges, each of them with an optional port
+func isNoProxy(hostport string, noProxy string) bool {
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		host = hostport
+	}
+	host = strings.ToLower(strings.Trim(host, "[]"))
+	ip := net.ParseIP(host)
+	for _, entry := range strings.Split(noProxy, ",") {
+		entry = strings.ToLower(strings.TrimSpace(entry))
+		if entry == "" {
+			continue
+		}
+		if entry == "*" {
+			return true
+		}
+		if _, cidr, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && cidr.Contains(ip) {
+				return true
+			}
+			continue
+		}
+		if entryHost, entryPort, err := net.SplitHostPort(entry); err == nil {
+			if entryPort != port {
+				continue
+			}
+			entry = entryHost
+		}
+		entry = strings.Trim(entry, "[]")
+		if strings.HasPrefix(entry, "*.") {
+			entry = entry[1:]
+		}
+		if strings.HasPrefix(entry, ".") {
+			if strings.HasSuffix(host, entry) || host == entry[1:] {
+				return true
+			}
+			continue
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadCACertificates the system certificates with the PEM certificates of the file
+func loadCACertificates(path string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil || pool == nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no PEM certificate is found", path)
+	}
+	return pool, nil
+}
+
+// loadClientCertificate loads the client certificate from a PEM file with its key file,
+// or from a PKCS#12 file (.p12 or .pfx) with its passphrase
+func loadClientCertificate(certFile, keyFile, password string) (tls.Certificate, error) {
+	data, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	ext := strings.ToLower(filepath.Ext(certFile))
+	if ext == ".p12" || ext == ".pfx" {
+		if keyFile != "" {
+			return tls.Certificate{}, fmt.Errorf("The --client-key option cannot be specified with a PKCS#12 client certificate.")
+		}
+		blocks, err := pkcs12.ToPEM(data, password)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %v", certFile, err)
+		}
+		var certPEM, keyPEM []byte
+		for _, block := range blocks {
+			if block.Type == "CERTIFICATE" {
+				certPEM = append(certPEM, pem.EncodeToMemory(block)...)
+			} else {
+				keyPEM = append(keyPEM, pem.EncodeToMemory(block)...)
+			}
+		}
+		return tls.X509KeyPair(certPEM, keyPEM)
+	}
+
+	keyData := data
+	if keyFile != "" {
+		keyData, err = ioutil.ReadFile(keyFile)
+		if err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+	cert, err := tls.X509KeyPair(data, keyData)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("%s: %v", certFile, err)
+	}
+	return cert, nil
+}
+
+// getSecureAccessDomain the secure access domain of a kintone domain, "example.cybozu.com" => "example.s.cybozu.com"
+func getSecureAccessDomain(domain string) string {
+	index := strings.Index(domain, ".")
+	if index < 0 {
+		return domain
+	}
+	for _, base := range SECURE_ACCESS_DOMAINS {
+		if domain[index+1:] == base {
+			return domain[:index] + ".s." + base
+		}
+	}
+	// already the secure access domain, or another domain
+	return domain
+}
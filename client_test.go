@@ -0,0 +1,328 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeClientCertificate writes a self-signed client certificate and its key as PEM files
+func writeClientCertificate(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "cli-kintone"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, "client.crt")
+	keyFile := filepath.Join(dir, "client.key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return cert, certFile, keyFile
+}
+
+func TestClientCertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "client-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { config = Configure{} }()
+	cert, certFile, keyFile := writeClientCertificate(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.PeerCertificates[0].Subject.CommonName))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(cert)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+	rootCAs := x509.NewCertPool()
+	rootCAs.AddCert(server.Certificate())
+
+	get := func() (string, error) {
+		transport, err := newTransport()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if transport.TLSClientConfig == nil {
+			transport.TLSClientConfig = &tls.Config{}
+		}
+		transport.TLSClientConfig.RootCAs = rootCAs
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		return string(body), err
+	}
+
+	config = Configure{}
+	if _, err := get(); err == nil {
+		t.Error("the request without the client certificate must fail")
+	}
+
+	config.ClientCert = certFile
+	config.ClientKey = keyFile
+	body, err := get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "cli-kintone" {
+		t.Errorf("the client certificate must be sent but %s", body)
+	}
+
+	// the key in the certificate file
+	both, _ := ioutil.ReadFile(certFile)
+	key, _ := ioutil.ReadFile(keyFile)
+	config.ClientCert = filepath.Join(dir, "client.pem")
+	config.ClientKey = ""
+	if err := ioutil.WriteFile(config.ClientCert, append(both, key...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := get(); err != nil {
+		t.Error(err)
+	}
+
+	config.ClientCert = certFile
+	if _, err := newTransport(); err == nil {
+		t.Error("the certificate without the key must be an error")
+	}
+}
+
+func TestGetSecureAccessDomain(t *testing.T) {
+	tests := []struct {
+		domain   string
+		expected string
+	}{
+		{"example.cybozu.com", "example.s.cybozu.com"},
+		{"example.kintone.com", "example.s.kintone.com"},
+		{"example.cybozu.cn", "example.s.cybozu.cn"},
+		{"example.s.cybozu.com", "example.s.cybozu.com"},
+		{"kintone.example.com", "kintone.example.com"},
+		{"localhost", "localhost"},
+	}
+	for _, test := range tests {
+		if actual := getSecureAccessDomain(test.domain); actual != test.expected {
+			t.Errorf("the secure access domain of %s must be %s but %s", test.domain, test.expected, actual)
+		}
+	}
+}
+
+func TestIsNoProxy(t *testing.T) {
+	noProxy := "localhost, .internal.example, example.org, 10.0.0.0/8, 192.168.0.1, proxy.example:8080"
+	tests := []struct {
+		host     string
+		expected bool
+	}{
+		{"localhost", true},
+		{"localhost:443", true},
+		{"a.internal.example", true},
+		{"internal.example", true},
+		{"example.org", true},
+		{"www.example.org", true},
+		{"notexample.org", false},
+		{"10.1.2.3:443", true},
+		{"11.1.2.3", false},
+		{"192.168.0.1", true},
+		{"proxy.example:8080", true},
+		{"proxy.example:443", false},
+		{"example.cybozu.com", false},
+	}
+	for _, test := range tests {
+		if actual := isNoProxy(test.host, noProxy); actual != test.expected {
+			t.Errorf("isNoProxy(%s) must be %v", test.host, test.expected)
+		}
+	}
+	if !isNoProxy("example.cybozu.com:443", "*") {
+		t.Error("* must match all the hosts")
+	}
+}
+
+func TestProxy(t *testing.T) {
+	defer func() { config = Configure{} }()
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte("user:p@ss")) {
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		w.Write([]byte(r.URL.Host))
+	}))
+	defer proxy.Close()
+
+	noProxy, ok := os.LookupEnv("NO_PROXY")
+	os.Setenv("NO_PROXY", "direct.example")
+	defer func() {
+		if ok {
+			os.Setenv("NO_PROXY", noProxy)
+		} else {
+			os.Unsetenv("NO_PROXY")
+		}
+	}()
+
+	config = Configure{}
+	config.Proxy = strings.Replace(proxy.URL, "http://", "user:p%40ss@", 1)
+	transport, err := newTransport()
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := (&http.Client{Transport: transport}).Get("http://kintone.example/k/v1/app.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "kintone.example" {
+		t.Errorf("the request must be sent to the proxy with its credentials: %d %s", resp.StatusCode, body)
+	}
+
+	req, _ := http.NewRequest("GET", "https://direct.example/k/v1/app.json", nil)
+	if u, err := transport.Proxy(req); err != nil || u != nil {
+		t.Errorf("the host of NO_PROXY must not be proxied: %v", u)
+	}
+
+	config.Proxy = "ftp://proxy.example"
+	if _, err := newTransport(); err == nil {
+		t.Error("the scheme of the proxy must be checked")
+	}
+}
+
+func TestCACertificate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "ca-cert")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { config = Configure{} }()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+	caFile := filepath.Join(dir, "ca.pem")
+	if err := ioutil.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	get := func() error {
+		transport, err := newTransport()
+		if err != nil {
+			return err
+		}
+		resp, err := (&http.Client{Transport: transport}).Get(server.URL)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	config = Configure{}
+	if err := get(); err == nil {
+		t.Error("the certificate of the server must not be trusted without --ca-cert")
+	}
+	config.CACert = caFile
+	if err := get(); err != nil {
+		t.Error(err)
+	}
+	config.CACert = filepath.Join(dir, "not-found.pem")
+	if err := get(); err == nil {
+		t.Error("a missing CA file must be an error")
+	}
+}
+
+// resetTransport forgets the transport created by getTransport, so that the next one is created from the options
+func resetTransport() {
+	sharedTransport, sharedTransportErr, sharedTransportOnce = nil, nil, sync.Once{}
+}
+
+func TestBaseURL(t *testing.T) {
+	defer func() {
+		config = Configure{}
+		resetTransport()
+	}()
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.Method+" "+r.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"records": []}`))
+	}))
+	defer server.Close()
+
+	config = Configure{}
+	config.BaseURL = server.URL + "/kintone/"
+	config.AppID = 1
+	config.APIToken = []string{"token"}
+	config.GuestSpaceID = 2
+	resetTransport()
+	if err := checkOptions(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Domain != strings.TrimPrefix(server.URL, "http://") {
+		t.Errorf("the domain must be the host of the base URL but %s", config.Domain)
+	}
+	app, err := makeApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the bulkRequest code
+	req, err := newRequest(app, "GET", "records", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := Do(app, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	// go-kintone
+	if _, err := app.Download("file-key"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(paths) != 2 || paths[0] != "GET /kintone/k/guest/2/v1/records.json" ||
+		!strings.HasPrefix(paths[1], "GET /kintone/k/") || !strings.HasSuffix(paths[1], "/file.json") {
+		t.Errorf("the requests must be sent to the base URL: %v", paths)
+	}
+
+	for _, invalid := range []string{"localhost:8080", "ftp://localhost", "http://localhost?a=1"} {
+		if _, err := parseBaseURL(invalid); err == nil {
+			t.Errorf("%s must be an invalid base URL", invalid)
+		}
+	}
+}
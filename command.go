@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+
+	flags "github.com/jessevdk/go-flags"
+	"github.com/kintone-labs/go-kintone"
+)
+
+// command a command of the command line, which sets its options to config before it is executed
+type command interface {
+	apply()
+}
+
+// ExportCommand export the records of the app to stdout
+type ExportCommand struct {
+	ConnectionOptions `group:"Connection Options"`
+	FormatOptions     `group:"Format Options"`
+	QueryOptions      `group:"Query Options"`
+	ExportOptions     `group:"Export Options"`
+	AttachmentOptions `group:"Attachment Options"`
+	ProgressOptions   `group:"Progress Options"`
+	RequestOptions    `group:"Request Options"`
+}
+
+func (cmd *ExportCommand) apply() {
+	config.ConnectionOptions = cmd.ConnectionOptions
+	config.FormatOptions = cmd.FormatOptions
+	config.QueryOptions = cmd.QueryOptions
+	config.ExportOptions = cmd.ExportOptions
+	config.AttachmentOptions = cmd.AttachmentOptions
+	config.ProgressOptions = cmd.ProgressOptions
+	config.RequestOptions = cmd.RequestOptions
+	config.IsExport = true
+}
+
+// Execute runs the export command
+func (cmd *ExportCommand) Execute(args []string) error {
+	return runCommand(runExport)
+}
+
+// ImportCommand import the records from the file of "-f", or from stdin
+type ImportCommand struct {
+	ConnectionOptions `group:"Connection Options"`
+	FormatOptions     `group:"Format Options"`
+	ImportOptions     `group:"Import Options"`
+	QueryOptions      `group:"Query Options"`
+	AttachmentOptions `group:"Attachment Options"`
+	DryRunOptions     `group:"Dry Run Options"`
+	ProgressOptions   `group:"Progress Options"`
+	RequestOptions    `group:"Request Options"`
+}
+
+func (cmd *ImportCommand) apply() {
+	config.ConnectionOptions = cmd.ConnectionOptions
+	config.FormatOptions = cmd.FormatOptions
+	config.ImportOptions = cmd.ImportOptions
+	config.QueryOptions = cmd.QueryOptions
+	config.AttachmentOptions = cmd.AttachmentOptions
+	config.DryRunOptions = cmd.DryRunOptions
+	config.ProgressOptions = cmd.ProgressOptions
+	config.RequestOptions = cmd.RequestOptions
+	config.IsImport = true
+}
+
+// Execute runs the import command
+func (cmd *ImportCommand) Execute(args []string) error {
+	return runCommand(runImport)
+}
+
+// DeleteCommand delete the records of the query, or all the records with "--all"
+type DeleteCommand struct {
+	All               bool `long:"all" description:"Delete all the records of the app. Required without \"-q\""`
+	ConnectionOptions `group:"Connection Options"`
+	QueryOptions      `group:"Query Options"`
+	DryRunOptions     `group:"Dry Run Options"`
+	RequestOptions    `group:"Request Options"`
+}
+
+func (cmd *DeleteCommand) apply() {
+	config.ConnectionOptions = cmd.ConnectionOptions
+	config.QueryOptions = cmd.QueryOptions
+	config.DryRunOptions = cmd.DryRunOptions
+	config.RequestOptions = cmd.RequestOptions
+}
+
+// Execute runs the delete command
+func (cmd *DeleteCommand) Execute(args []string) error {
+	if config.Query == "" && !cmd.All {
+		return fmt.Errorf("Specify the records to delete with the -q option, or all the records with the --all option.")
+	}
+	return runCommand(runDelete)
+}
+
+// SchemaCommand show the fields of the app as JSON
+type SchemaCommand struct {
+	ConnectionOptions `group:"Connection Options"`
+	RequestOptions    `group:"Request Options"`
+}
+
+func (cmd *SchemaCommand) apply() {
+	config.ConnectionOptions = cmd.ConnectionOptions
+	config.RequestOptions = cmd.RequestOptions
+}
+
+// Execute runs the schema command
+func (cmd *SchemaCommand) Execute(args []string) error {
+	return runCommand(runSchema)
+}
+
+// CountCommand show the number of the records of the query, or of all the records
+type CountCommand struct {
+	ConnectionOptions `group:"Connection Options"`
+	QueryOptions      `group:"Query Options"`
+	RequestOptions    `group:"Request Options"`
+}
+
+func (cmd *CountCommand) apply() {
+	config.ConnectionOptions = cmd.ConnectionOptions
+	config.QueryOptions = cmd.QueryOptions
+	config.RequestOptions = cmd.RequestOptions
+}
+
+// Execute runs the count command
+func (cmd *CountCommand) Execute(args []string) error {
+	return runCommand(runCount)
+}
+
+// newCommandParser the parser of the commands
+func newCommandParser() *flags.Parser {
+	parser := flags.NewNamedParser(NAME, flags.HelpFlag|flags.PassDoubleDash)
+	parser.AddCommand("export", "Export the records of the app to stdout", "Export the records of the app to stdout as CSV or JSON.", &ExportCommand{})
+	parser.AddCommand("import", "Import the records into the app", "Import the records from the file of \"-f\", or from stdin if it is not specified.", &ImportCommand{})
+	parser.AddCommand("delete", "Delete the records of the app", "Delete the records of the query of \"-q\", or all the records with \"--all\".", &DeleteCommand{})
+	parser.AddCommand("schema", "Show the fields of the app", "Show the fields of the app as JSON.", &SchemaCommand{})
+	parser.AddCommand("count", "Count the records of the app", "Show the number of the records of the query of \"-q\", or of all the records.", &CountCommand{})
+	return parser
+}
+
+// runCommandLine parses the command line starting with a command, and runs the command
+func runCommandLine(args []string) {
+	setDefaultOptions()
+	parser := newCommandParser()
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		cmd.(command).apply()
+		if err := applyProfile(getOptions(parser.Active.Group)); err != nil {
+			return err
+		}
+		return cmd.Execute(args)
+	}
+	_, err := parser.ParseArgs(args)
+	if err == nil {
+		return
+	}
+	if flagsErr, ok := err.(*flags.Error); ok {
+		if flagsErr.Type == flags.ErrHelp {
+			fmt.Println(err)
+			os.Exit(0)
+		}
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Printf("\nTry '%s %s --help' for more information.\n", os.Args[0], args[0])
+		os.Exit(1)
+	}
+	log.Fatal(err)
+}
+
+// runCommand runs the action of a command with the app of the options
+func runCommand(action func(app *kintone.App) error) error {
+	if err := readCredentials(); err != nil {
+		return err
+	}
+	if !hasConnectionOptions() {
+		return fmt.Errorf("The -a option, and the -t option or the -d and -u options are required.")
+	}
+	if err := checkOptions(); err != nil {
+		return err
+	}
+	app, err := makeApp()
+	if err != nil {
+		return err
+	}
+	err = action(app)
+	closeAttachments()
+	return err
+}
+
+// setDefaultOptions sets the default values of all the options, the command sets its options over them
+func setDefaultOptions() {
+	flags.NewParser(&config, flags.None).ParseArgs([]string{})
+}
+
+// runDelete deletes the records of the query, only counts them in dry-run mode
+func runDelete(app *kintone.App) error {
+	count, err := countRecords(app, config.Query)
+	if err != nil {
+		return err
+	}
+	if config.DryRun {
+		showTimeLog()
+		fmt.Printf("DRY RUN DONE: %d records will be deleted\n", count)
+		return nil
+	}
+	if err := deleteRecords(app, config.Query); err != nil {
+		return err
+	}
+	showTimeLog()
+	fmt.Printf("DONE: %d records were deleted\n", count)
+	return nil
+}
+
+// runSchema shows the fields of the app as JSON
+func runSchema(app *kintone.App) error {
+	fields, err := app.Fields()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(fields, "", "  ")
+	if err != nil {
+		return err
+	}
+	fmt.Println(string(data))
+	return nil
+}
+
+// runCount shows the number of the records of the query
+func runCount(app *kintone.App) error {
+	count, err := countRecords(app, config.Query)
+	if err != nil {
+		return err
+	}
+	fmt.Println(count)
+	return nil
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// parseCommand parses the command line and sets the options of the command to config without running it
+func parseCommand(t *testing.T, args ...string) {
+	setDefaultOptions()
+	parser := newCommandParser()
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+		cmd.(command).apply()
+		return nil
+	}
+	if _, err := parser.ParseArgs(args); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestExportCommand(t *testing.T) {
+	defer func() { config = Configure{} }()
+	parseCommand(t, "export", "-d", "example.cybozu.com", "-a", "1", "-t", "token", "-c", "a,b", "--concurrency", "3")
+	if !config.IsExport || config.IsImport {
+		t.Error("the command must export")
+	}
+	if config.Domain != "example.cybozu.com" || config.AppID != 1 || getAPIToken() != "token" {
+		t.Errorf("wrong connection options %+v", config.ConnectionOptions)
+	}
+	if len(config.Fields) != 1 || config.Fields[0] != "a,b" || config.Concurrency != 3 {
+		t.Errorf("wrong export options %v %d", config.Fields, config.Concurrency)
+	}
+	// the options of the import have their default values
+	if config.Format != "" || config.Line != 1 || config.OnError != "stop" || config.MaxFileSize != 1024 {
+		t.Errorf("wrong default options %+v", config)
+	}
+}
+
+func TestImportCommand(t *testing.T) {
+	defer func() { config = Configure{} }()
+	parseCommand(t, "import", "-a", "1", "-t", "token1,token2", "-t", "token3", "-t", "token1", "-f", "data.csv", "-D", "-q", "num > 1", "--dry-run")
+	if !config.IsImport || config.IsExport {
+		t.Error("the command must import")
+	}
+	if getAPIToken() != "token1,token2,token3" {
+		t.Errorf("wrong API tokens %v", config.APIToken)
+	}
+	if config.FilePath != "data.csv" || !config.DeleteAll || config.Query != "num > 1" || !config.DryRun {
+		t.Errorf("wrong import options %+v", config)
+	}
+}
+
+func TestCommandOptions(t *testing.T) {
+	defer func() { config = Configure{} }()
+	parser := newCommandParser()
+	parser.CommandHandler = func(cmd flags.Commander, args []string) error { return nil }
+	// the options of the import are not options of the export
+	if _, err := parser.ParseArgs([]string{"export", "-a", "1", "-f", "data.csv"}); err == nil {
+		t.Error("-f must be an unknown option of the export")
+	}
+	if _, err := parser.ParseArgs([]string{"count", "-a", "1", "-c", "a"}); err == nil {
+		t.Error("-c must be an unknown option of the count")
+	}
+	if _, err := parser.ParseArgs([]string{"unknown"}); err == nil {
+		t.Error("unknown must be an unknown command")
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/howeyc/gopass"
+)
+
+// readCredentials reads the credentials of "--password-file", "--api-token-file",
+// "--basic-auth-password-file" and "--password-stdin", which replace those of the options
+func readCredentials() error {
+	var err error
+	if config.PasswordFile != "" && config.PasswordStdin {
+		return fmt.Errorf("The --password-file and --password-stdin options cannot be specified together.")
+	}
+	if config.PasswordFile != "" {
+		if config.Password, err = readSecretFile(config.PasswordFile); err != nil {
+			return err
+		}
+	}
+	if config.PasswordStdin {
+		if config.IsImport && config.FilePath == "" {
+			return fmt.Errorf("The --password-stdin option cannot be specified with the import from stdin, specify the input file with the -f option.")
+		}
+		if config.Password, err = readSecret(os.Stdin); err != nil {
+			return fmt.Errorf("Cannot read the password from stdin: %v", err)
+		}
+	}
+	if config.APITokenFile != "" {
+		token, err := readSecretFile(config.APITokenFile)
+		if err != nil {
+			return err
+		}
+		config.APIToken = []string{token}
+	}
+	if config.BasicAuthFile != "" {
+		if config.BasicAuthPassword, err = readSecretFile(config.BasicAuthFile); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readSecretFile reads the secret in the first line of the file
+func readSecretFile(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	secret, err := readSecret(file)
+	if err != nil {
+		return "", fmt.Errorf("%s: %v", path, err)
+	}
+	return secret, nil
+}
+
+// readSecret reads the first line, without the line break
+func readSecret(reader io.Reader) (string, error) {
+	line, err := bufio.NewReader(reader).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return "", fmt.Errorf("the secret is empty")
+	}
+	return line, nil
+}
+
+// isTerminal reports whether the file is a terminal
+func isTerminal(file *os.File) bool {
+	info, err := file.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// askPassword asks the password on the terminal, options are the ways to specify it without the terminal
+func askPassword(name string, options string) (string, error) {
+	if !isTerminal(os.Stdin) {
+		return "", fmt.Errorf("The %s is not specified, and cannot be asked because stdin is not a terminal. Specify it with %s.", strings.ToLower(name), options)
+	}
+	fmt.Print(name + ": ")
+	pass, err := gopass.GetPasswd()
+	if err != nil {
+		return "", err
+	}
+	return string(pass), nil
+}
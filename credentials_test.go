@@ -0,0 +1,92 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSecret(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"secret", "secret"},
+		{"secret\n", "secret"},
+		{"se cret \r\nsecond line\n", "se cret "},
+	}
+	for _, test := range tests {
+		actual, err := readSecret(strings.NewReader(test.input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != test.expected {
+			t.Errorf("%q must be read as %q but %q", test.input, test.expected, actual)
+		}
+	}
+	if _, err := readSecret(strings.NewReader("\n")); err == nil {
+		t.Error("an empty secret must be an error")
+	}
+}
+
+func TestReadCredentials(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credentials")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer func() { config = Configure{} }()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	config = Configure{}
+	config.Password = "argv"
+	config.PasswordFile = write("password", "file-password\n")
+	config.APITokenFile = write("token", "file-token")
+	config.BasicAuthFile = write("basic", "basic-password\n")
+	if err := readCredentials(); err != nil {
+		t.Fatal(err)
+	}
+	if config.Password != "file-password" || getAPIToken() != "file-token" || config.BasicAuthPassword != "basic-password" {
+		t.Errorf("wrong credentials %+v", config.ConnectionOptions)
+	}
+
+	config = Configure{}
+	config.PasswordFile = filepath.Join(dir, "not-found")
+	if err := readCredentials(); err == nil {
+		t.Error("a missing password file must be an error")
+	}
+
+	config = Configure{}
+	config.PasswordStdin = true
+	config.IsImport = true
+	if err := readCredentials(); err == nil {
+		t.Error("--password-stdin must be an error with the import from stdin")
+	}
+}
+
+func TestAskPasswordWithoutTerminal(t *testing.T) {
+	file, err := ioutil.TempFile("", "stdin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if isTerminal(file) {
+		t.Fatal("a file must not be a terminal")
+	}
+
+	stdin := os.Stdin
+	os.Stdin = file
+	defer func() { os.Stdin = stdin }()
+	if _, err := askPassword("Password", "-p"); err == nil || !strings.Contains(err.Error(), "not a terminal") {
+		t.Errorf("the password must not be asked without a terminal: %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// DOWNLOAD_INDEX_FILE the file in the directory of "-b" which records the attachment files downloaded
+const DOWNLOAD_INDEX_FILE = ".cli-kintone-files.json"
+
+// DOWNLOAD_QUEUE_SIZE the number of the files waiting for a worker before the records stop being written
+const DOWNLOAD_QUEUE_SIZE = 100
+
+// downloadedFile an attachment file saved in the directory of "-b"
+type downloadedFile struct {
+	FileKey string `json:"fileKey"`
+	Size    uint64 `json:"size"`
+	SHA256  string `json:"sha256"`
+}
+
+type downloadJob struct {
+	file kintone.File
+	path string // relative to the directory of "-b"
+}
+
+// FileDownloader downloads the attachment files with concurrent workers.
+// The path of each file is decided when the record is written, and the file is downloaded after.
+// The files with the same FileKey or the same content are stored once.
+type FileDownloader struct {
+	app      *kintone.App
+	manifest *AttachmentManifest
+	archive  *AttachmentArchive // the files are written into the archive instead of the directory of "-b"
+	jobs     chan *downloadJob
+	pending  sync.WaitGroup
+	workers  sync.WaitGroup
+	mu       sync.Mutex
+	err      error
+	paths    map[string]string          // FileKey => path
+	hashes   map[string]string          // SHA-256 => path
+	index    map[string]*downloadedFile // path => file
+}
+
+// downloader the downloader of the running export, started by the first file
+var downloader *FileDownloader
+
+func newFileDownloader(app *kintone.App) (*FileDownloader, error) {
+	concurrency := int(config.Concurrency)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > EXPORT_CONCURRENCY_LIMIT {
+		concurrency = EXPORT_CONCURRENCY_LIMIT
+	}
+	d := &FileDownloader{
+		app:    app,
+		jobs:   make(chan *downloadJob, DOWNLOAD_QUEUE_SIZE),
+		paths:  make(map[string]string),
+		hashes: make(map[string]string),
+		index:  make(map[string]*downloadedFile),
+	}
+	if config.AttachmentsArchive != "" {
+		archive, err := createAttachmentArchive(config.AttachmentsArchive)
+		if err != nil {
+			return nil, err
+		}
+		d.archive = archive
+	} else {
+		data, err := ioutil.ReadFile(filepath.Join(config.FileDir, DOWNLOAD_INDEX_FILE))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &d.index); err != nil {
+				return nil, fmt.Errorf("The file %s is broken: %v", DOWNLOAD_INDEX_FILE, err)
+			}
+		}
+	}
+	manifest, err := openManifest()
+	if err != nil {
+		return nil, err
+	}
+	d.manifest = manifest
+
+	initClient(d.app)
+	for i := 0; i < concurrency; i++ {
+		d.workers.Add(1)
+		go d.work()
+	}
+	return d, nil
+}
+
+// isDownloaded reports whether the file was already downloaded to the path by a previous export
+func (d *FileDownloader) isDownloaded(path string, file kintone.File) bool {
+	info, err := os.Stat(filepath.Join(config.FileDir, path))
+	if err != nil || !info.Mode().IsRegular() || uint64(info.Size()) != file.Size {
+		return false
+	}
+	saved := d.index[filepath.ToSlash(path)]
+	// without the index, the size is compared only
+	return saved == nil || saved.FileKey == file.FileKey
+}
+
+// Add queues the files of the field to download into dir, and replaces the names of the files with their paths.
+// The files are listed in the manifest with the record, the field code and the row of the table.
+func (d *FileDownloader) Add(files kintone.FileField, dir string, recordID uint64, fieldCode string, subtableRowID uint64) error {
+	// the same names on each run, so that the files downloaded before are found
+	isSameName := config.SkipExisting || config.Checkpoint != "" || d.archive != nil
+	if d.archive == nil {
+		if err := os.MkdirAll(filepath.Join(config.FileDir, dir), 0777); err != nil {
+			return err
+		}
+	}
+	used := make(map[string]bool)
+	for idx, file := range files {
+		fileName := getNumberedFileName(file.Name, used)
+		path := filepath.Join(dir, fileName)
+		for !isSameName && isExistFile(filepath.Join(config.FileDir, path)) {
+			fileName = getNumberedFileName(file.Name, used)
+			path = filepath.Join(dir, fileName)
+		}
+
+		entry := &ManifestEntry{RecordID: recordID, FieldCode: fieldCode, SubtableRowID: subtableRowID,
+			FileName: file.Name, ContentType: file.ContentType, fileKey: file.FileKey}
+		d.mu.Lock()
+		saved, ok := d.paths[file.FileKey]
+		if !ok {
+			d.paths[file.FileKey] = path
+		}
+		d.mu.Unlock()
+		if ok {
+			// the same file in another field
+			path = saved
+		}
+		files[idx].Name = path
+		entry.Path = path
+		d.manifest.Add(entry)
+		if ok {
+			continue
+		}
+
+		if isSameName && d.archive == nil && d.isDownloaded(path, file) {
+			d.mu.Lock()
+			if saved := d.index[filepath.ToSlash(path)]; saved != nil && d.hashes[saved.SHA256] == "" {
+				d.hashes[saved.SHA256] = path
+			}
+			d.mu.Unlock()
+			continue
+		}
+		d.pending.Add(1)
+		d.jobs <- &downloadJob{file: file, path: path}
+	}
+	if len(d.manifest.pending) >= EXPORT_ROW_LIMIT {
+		// write the manifest as the export goes, instead of keeping all the entries until the end
+		return d.Wait()
+	}
+	return nil
+}
+
+func (d *FileDownloader) work() {
+	defer d.workers.Done()
+	for job := range d.jobs {
+		d.mu.Lock()
+		failed := d.err != nil
+		d.mu.Unlock()
+		if !failed {
+			if err := d.download(job); err != nil {
+				d.mu.Lock()
+				if d.err == nil {
+					d.err = err
+				}
+				d.mu.Unlock()
+			}
+		}
+		d.pending.Done()
+	}
+}
+
+// download the file to a temporary file, and link it to the file with the same content if any.
+// The file of the archive is streamed into it instead.
+func (d *FileDownloader) download(job *downloadJob) error {
+	data, err := d.app.Download(job.file.FileKey)
+	if err != nil {
+		return err
+	}
+	if closer, ok := data.Reader.(io.Closer); ok {
+		defer closer.Close()
+	}
+
+	hash := sha256.New()
+	if d.archive != nil {
+		if err := d.archive.Write(job.path, int64(job.file.Size), io.TeeReader(data.Reader, hash)); err != nil {
+			return err
+		}
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		d.index[filepath.ToSlash(job.path)] = &downloadedFile{FileKey: job.file.FileKey, Size: job.file.Size, SHA256: hex.EncodeToString(hash.Sum(nil))}
+		return nil
+	}
+
+	path := filepath.Join(config.FileDir, job.path)
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".download")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	size, err := io.Copy(io.MultiWriter(tmp, hash), data.Reader)
+	if err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	linked := false
+	if same := d.hashes[sum]; same != "" && same != job.path {
+		os.Remove(path)
+		linked = os.Link(filepath.Join(config.FileDir, same), path) == nil
+	}
+	if !linked {
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return err
+		}
+		d.hashes[sum] = job.path
+	}
+	d.index[filepath.ToSlash(job.path)] = &downloadedFile{FileKey: job.file.FileKey, Size: uint64(size), SHA256: sum}
+	return nil
+}
+
+// Wait waits until the files queued are downloaded and writes them to the manifest, and returns the first error
+func (d *FileDownloader) Wait() error {
+	d.pending.Wait()
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.err != nil {
+		return d.err
+	}
+	return d.manifest.Flush(d.index)
+}
+
+// Close waits for the downloads, and saves the manifest and the index of the files
+func (d *FileDownloader) Close() error {
+	close(d.jobs)
+	d.workers.Wait()
+	err := d.err
+	if err == nil {
+		err = d.manifest.Flush(d.index)
+	}
+	if err != nil {
+		d.manifest.file.Close()
+		if d.archive != nil {
+			os.Remove(d.manifest.file.Name())
+			d.archive.Close()
+		}
+		return err
+	}
+	if d.archive != nil {
+		return closeManifest(d.manifest, d.archive)
+	}
+	if err := d.manifest.Close(); err != nil {
+		return err
+	}
+	return writeJSONFile(filepath.Join(config.FileDir, DOWNLOAD_INDEX_FILE), d.index)
+}
+
+// waitDownloads waits until the files of the records written are downloaded
+func waitDownloads() error {
+	if downloader == nil {
+		return nil
+	}
+	return downloader.Wait()
+}
+
+// finishDownloads waits for the downloads of the export, and completes the manifest
+func finishDownloads() error {
+	if downloader == nil {
+		if config.FileDir == "" && config.AttachmentsArchive == "" {
+			return nil
+		}
+		// no file was downloaded after the checkpoint, or no file at all
+		manifest, err := openManifest()
+		if err != nil {
+			return err
+		}
+		if config.AttachmentsArchive != "" {
+			archive, err := createAttachmentArchive(config.AttachmentsArchive)
+			if err != nil {
+				manifest.file.Close()
+				os.Remove(manifest.file.Name())
+				return err
+			}
+			return closeManifest(manifest, archive)
+		}
+		return manifest.Close()
+	}
+	err := downloader.Close()
+	downloader = nil
+	return err
+}
+
+// getNumberedFileName the name of a file of a field, numbered like "name (1).ext" when the name is used in the field.
+// The names do not depend on the files on disk, so that the next export finds the files downloaded before.
+func getNumberedFileName(filename string, used map[string]bool) string {
+	fileExt := filepath.Ext(filename)
+	fileBaseName := filename[0 : len(filename)-len(fileExt)]
+	fileNameOutput := filename
+	for index := 1; used[fileNameOutput]; index++ {
+		fileNameOutput = fmt.Sprintf("%s (%d)%s", fileBaseName, index, fileExt)
+	}
+	used[fileNameOutput] = true
+	return fileNameOutput
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+func TestGetNumberedFileName(t *testing.T) {
+	used := make(map[string]bool)
+	expected := []string{"a.txt", "a (1).txt", "b", "a (2).txt"}
+	for i, name := range []string{"a.txt", "a.txt", "b", "a.txt"} {
+		if actual := getNumberedFileName(name, used); actual != expected[i] {
+			t.Errorf("file name must be %q but %q", expected[i], actual)
+		}
+	}
+}
+
+func TestFileDownloader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.FileDir = dir
+	config.Concurrency = 4
+	config.SkipExisting = true
+
+	fake := newFakeKintone(t, newTestApp())
+	for key, content := range map[string]string{"key1": "same", "key2": "same", "key3": "other"} {
+		fake.files[key] = &fakeFile{Name: "a.txt", ContentType: "text/plain", Data: []byte(content)}
+	}
+	app := fake.App(TEST_APP_ID)
+
+	export := func() []kintone.FileField {
+		fields := []kintone.FileField{
+			{{FileKey: "key1", Name: "a.txt", Size: 4}, {FileKey: "key2", Name: "a.txt", Size: 4}},
+			{{FileKey: "key3", Name: "b.txt", Size: 5}},
+			{{FileKey: "key1", Name: "a.txt", Size: 4}},
+		}
+		for i, field := range fields {
+			if err := downloadFile(app, field, []string{"file-1", "file-2", "file-3"}[i], uint64(i+1), "file", 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := finishDownloads(); err != nil {
+			t.Fatal(err)
+		}
+		return fields
+	}
+
+	fields := export()
+	expected := []string{filepath.Join("file-1", "a.txt"), filepath.Join("file-1", "a (1).txt"), filepath.Join("file-2", "b.txt"), filepath.Join("file-1", "a.txt")}
+	actual := []string{fields[0][0].Name, fields[0][1].Name, fields[1][0].Name, fields[2][0].Name}
+	for i := range expected {
+		if actual[i] != expected[i] {
+			t.Errorf("path of the file %d must be %q but %q", i, expected[i], actual[i])
+		}
+	}
+	if len(fake.Requests) != 3 {
+		t.Errorf("each file must be downloaded once but %d downloads", len(fake.Requests))
+	}
+	first, _ := os.Stat(filepath.Join(dir, expected[0]))
+	second, _ := os.Stat(filepath.Join(dir, expected[1]))
+	if first == nil || second == nil || !os.SameFile(first, second) {
+		t.Error("the files with the same content must be stored once")
+	}
+
+	manifest, err := os.Open(filepath.Join(dir, "manifest.csv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(manifest).ReadAll()
+	manifest.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 5 {
+		t.Fatalf("the manifest must list 4 files but %d", len(rows)-1)
+	}
+	sum := sha256.Sum256([]byte("same"))
+	expectedRow := []string{"3", "file", "", "a.txt", expected[3], "4", "", hex.EncodeToString(sum[:])}
+	if !reflect.DeepEqual(rows[4], expectedRow) {
+		t.Errorf("the manifest entry must be %v but %v", expectedRow, rows[4])
+	}
+
+	// only the changed file is downloaded again
+	fake.files["key4"] = &fakeFile{Name: "a.txt", ContentType: "text/plain", Data: []byte("new")}
+	fake.Requests = nil
+	fields = []kintone.FileField{{{FileKey: "key1", Name: "a.txt", Size: 4}, {FileKey: "key4", Name: "a.txt", Size: 3}}}
+	if err := downloadFile(app, fields[0], "file-1", 1, "file", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := finishDownloads(); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Requests) != 1 {
+		t.Errorf("only the changed file must be downloaded but %v", fake.Requests)
+	}
+	data, _ := ioutil.ReadFile(filepath.Join(dir, expected[1]))
+	if string(data) != "new" {
+		t.Errorf("the changed file must be replaced but %q", string(data))
+	}
+	data, _ = ioutil.ReadFile(filepath.Join(dir, expected[0]))
+	if string(data) != "same" {
+		t.Errorf("the file linked to the changed file must be kept but %q", string(data))
+	}
+}
+
+func TestFileDownloaderArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "download")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.AttachmentsArchive = filepath.Join(dir, "out.zip")
+	config.Concurrency = 2
+
+	fake := newFakeKintone(t, newTestApp())
+	for _, key := range []string{"key1", "key2"} {
+		fake.files[key] = &fakeFile{Name: "a.txt", ContentType: "text/plain", Data: []byte("content")}
+	}
+	app := fake.App(TEST_APP_ID)
+
+	field := kintone.FileField{{FileKey: "key1", Name: "a.txt", Size: 7}, {FileKey: "key2", Name: "a.txt", Size: 7}}
+	if err := downloadFile(app, field, "file-1", 1, "file", 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := finishDownloads(); err != nil {
+		t.Fatal(err)
+	}
+
+	config.FileDir = config.AttachmentsArchive
+	source, err := openAttachmentSource(config.FileDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer source.zip.Close()
+	for _, file := range []string{field[0].Name, field[1].Name, "manifest.csv"} {
+		reader, _, err := source.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.Close()
+	}
+	if isExistFile(filepath.Join(dir, "file-1")) {
+		t.Error("the files must be written into the archive only")
+	}
+}
+
+func TestResumeManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+	config = Configure{}
+	config.FileDir = dir
+	config.Format = "json"
+	config.Checkpoint = filepath.Join(dir, "export.checkpoint")
+	index := map[string]*downloadedFile{"a.txt": {Size: 1}, "b.txt": {Size: 2}}
+
+	// interrupted before the first entry, and after it
+	for _, written := range []string{"[", "[\n{\"$id\":1,\"path\":\"a.txt\"}"} {
+		ioutil.WriteFile(getManifestPath(), []byte(written), 0666)
+		manifest, err := openManifest()
+		if err != nil {
+			t.Fatal(err)
+		}
+		manifest.Add(&ManifestEntry{RecordID: 2, Path: "b.txt"})
+		if err := manifest.Flush(index); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifest.Close(); err != nil {
+			t.Fatal(err)
+		}
+		data, _ := ioutil.ReadFile(getManifestPath())
+		var entries []ManifestEntry
+		if err := json.Unmarshal(data, &entries); err != nil || entries[len(entries)-1].RecordID != 2 {
+			t.Errorf("the resumed manifest must be a JSON array but %q: %v", string(data), err)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// EXPORT_CONCURRENCY_LIMIT The maximum number of the partitions fetched at the same time
+const EXPORT_CONCURRENCY_LIMIT = 10
+
+// EXPORT_PARTITIONS_PER_WORKER The number of the partitions of the $id range for each worker
+const EXPORT_PARTITIONS_PER_WORKER = 4
+
+// exportPartition the records whose $id is from "from" to "to", fetched in descending order of $id
+type exportPartition struct {
+	from  uint64
+	to    uint64
+	pages chan []*kintone.Record
+	err   error
+}
+
+// getRecordID get the smallest or the largest $id of the app, 0 if the app has no records
+func getRecordID(app *kintone.App, order string) (uint64, error) {
+	records, err := app.GetRecords([]string{"$id"}, "order by $id "+order+" limit 1")
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	return records[0].Id(), nil
+}
+
+// makeExportPartitions split the $id range from "from" to "to" into partitions, from the largest $id
+func makeExportPartitions(from, to uint64, count int) []*exportPartition {
+	if from > to {
+		return nil
+	}
+	size := (to - from + 1) / uint64(count)
+	if (to-from+1)%uint64(count) != 0 {
+		size++
+	}
+	partitions := make([]*exportPartition, 0, count)
+	for high := to; ; high -= size {
+		low := from
+		if high-from >= size {
+			low = high - size + 1
+		}
+		partitions = append(partitions, &exportPartition{from: low, to: high, pages: make(chan []*kintone.Record, 2)})
+		if low == from {
+			break
+		}
+	}
+	return partitions
+}
+
+// fetch the records of the partition page by page, until done is closed
+func (partition *exportPartition) fetch(app *kintone.App, fields []string, done chan struct{}) {
+	defer close(partition.pages)
+	to := partition.to
+	for {
+		query := fmt.Sprintf("$id >= %d and $id <= %d order by $id desc limit %d", partition.from, to, EXPORT_ROW_LIMIT)
+		records, err := app.GetRecords(fields, query)
+		if err != nil {
+			partition.err = err
+			return
+		}
+		if len(records) > 0 {
+			select {
+			case partition.pages <- records:
+			case <-done:
+				return
+			}
+		}
+		if len(records) < EXPORT_ROW_LIMIT {
+			return
+		}
+		to = records[len(records)-1].Id() - 1
+	}
+}
+
+// writeRecordsByPartitions fetches the partitions of the $id range with several workers,
+// and writes the records in descending order of $id as the seek method does.
+// id is the $id of the last record written before the checkpoint, or 0.
+func writeRecordsByPartitions(app *kintone.App, id uint64, writer io.Writer, row Row, hasTable bool, index uint64, fields []string, isAppendIdCustome bool, checkpoint *ExportCheckpoint) error {
+	concurrency := int(config.Concurrency)
+	if concurrency > EXPORT_CONCURRENCY_LIMIT {
+		concurrency = EXPORT_CONCURRENCY_LIMIT
+	}
+
+	from, err := getRecordID(app, "asc")
+	if err != nil {
+		return err
+	}
+	to, err := getRecordID(app, "desc")
+	if err != nil {
+		return err
+	}
+	if from == 0 && index == 0 {
+		checkNoRecord(nil)
+	}
+	if id > 0 {
+		to = id - 1
+	}
+	partitions := makeExportPartitions(from, to, concurrency*EXPORT_PARTITIONS_PER_WORKER)
+
+	initClient(app)
+	queue := make(chan *exportPartition, len(partitions))
+	for _, partition := range partitions {
+		queue <- partition
+	}
+	close(queue)
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < concurrency; i++ {
+		go func() {
+			for partition := range queue {
+				select {
+				case <-done:
+					return
+				default:
+				}
+				partition.fetch(app, fields, done)
+			}
+		}()
+	}
+
+	if isJSONFormat() && index == 0 {
+		writeHeaderJSON(writer)
+	}
+	for _, partition := range partitions {
+		for records := range partition.pages {
+			if isJSONFormat() {
+				index, err = writeRecordsJSON(app, writer, records, index, isAppendIdCustome)
+			} else {
+				index, err = writeRecordsCsv(app, writer, records, row, hasTable, index, isAppendIdCustome)
+			}
+			if err != nil {
+				return err
+			}
+			if err := checkpoint.Save(records[len(records)-1].Id(), index); err != nil {
+				return err
+			}
+		}
+		if partition.err != nil {
+			return partition.err
+		}
+	}
+	if isJSONFormat() {
+		writeFooterJSON(writer)
+	} else if index == 0 {
+		// no record, write the header only
+		writeHeaderCsv(writer, hasTable, row)
+	}
+	return nil
+}
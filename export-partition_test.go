@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestMakeExportPartitions(t *testing.T) {
+	partitions := makeExportPartitions(1, 10, 4)
+	expected := [][2]uint64{{8, 10}, {5, 7}, {2, 4}, {1, 1}}
+	if len(partitions) != len(expected) {
+		t.Fatalf("%d partitions must be made but %d", len(expected), len(partitions))
+	}
+	for i, partition := range partitions {
+		if partition.from != expected[i][0] || partition.to != expected[i][1] {
+			t.Errorf("partition[%d] must be %d - %d but %d - %d", i, expected[i][0], expected[i][1], partition.from, partition.to)
+		}
+	}
+
+	if partitions := makeExportPartitions(5, 6, 8); len(partitions) != 2 {
+		t.Errorf("a range of 2 records must be split into 2 partitions but %d", len(partitions))
+	}
+	if partitions := makeExportPartitions(5, 4, 8); len(partitions) != 0 {
+		t.Errorf("an empty range must not be split but %d partitions", len(partitions))
+	}
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
-	"path/filepath"
 	"reflect"
 	"regexp"
+	"strconv"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/kintone-labs/go-kintone"
 	"golang.org/x/text/transform"
@@ -19,6 +21,9 @@ const (
 	RECORD_NOT_FOUND    = "No record found. \nPlease check your query or permission settings."
 )
 
+// exportOutput the file of "--output", or stdout
+var exportOutput = os.Stdout
+
 func checkNoRecord(records []*kintone.Record) {
 	if len(records) < 1 {
 		fmt.Println(RECORD_NOT_FOUND)
@@ -75,8 +80,8 @@ func fixOrderCell(row Row) {
 	}
 }
 
-func downloadFile(app *kintone.App, field interface{}, dir string) error {
-	if config.FileDir == "" {
+func downloadFile(app *kintone.App, field interface{}, dir string, recordID uint64, fieldCode string, subtableRowID uint64) error {
+	if config.FileDir == "" && config.AttachmentsArchive == "" {
 		return nil
 	}
 
@@ -89,46 +94,14 @@ func downloadFile(app *kintone.App, field interface{}, dir string) error {
 		return nil
 	}
 
-	fileDir := fmt.Sprintf("%s%c%s", config.FileDir, os.PathSeparator, dir)
-	if err := os.MkdirAll(fileDir, 0777); err != nil {
-		return err
-	}
-	for idx, file := range v {
-		fileName := getUniqueFileName(file.Name, fileDir)
-		path := fmt.Sprintf("%s%c%s", fileDir, os.PathSeparator, fileName)
-		data, err := app.Download(file.FileKey)
+	if downloader == nil {
+		var err error
+		downloader, err = newFileDownloader(app)
 		if err != nil {
 			return err
 		}
-
-		fo, err := os.Create(path)
-		if err != nil {
-			return err
-		}
-		defer fo.Close()
-
-		// make a buffer to keep chunks that are read
-		buf := make([]byte, 256*1024)
-		for {
-			// read a chunk
-			n, err := data.Reader.Read(buf)
-			if err != nil && err != io.EOF {
-				return err
-			}
-			if n == 0 {
-				break
-			}
-
-			// write a chunk
-			if _, err := fo.Write(buf[:n]); err != nil {
-				return err
-			}
-		}
-
-		v[idx].Name = fmt.Sprintf("%s%c%s", dir, os.PathSeparator, fileName)
 	}
-
-	return nil
+	return downloader.Add(v, dir, recordID, fieldCode, subtableRowID)
 }
 
 func escapeCol(s string) string {
@@ -142,11 +115,29 @@ func exportRecordsBySeekMethod(app *kintone.App, writer io.Writer, fields []stri
 		return err
 	}
 
-	if config.Format == "json" {
-		err := writeRecordsBySeekMethodForJson(app, 0, writer, 0, fields, true, isAppendIdCustome)
+	checkpoint, err := loadExportCheckpoint(exportOutput, writer, fields)
+	if err != nil {
+		return err
+	}
+	id, index := checkpoint.Start()
+
+	if config.Concurrency > 1 {
+		err = writeRecordsByPartitions(app, id, writer, row, hasTable, index, fields, isAppendIdCustome, checkpoint)
+	} else if isJSONFormat() {
+		err = writeRecordsBySeekMethodForJson(app, id, writer, index, fields, index == 0, isAppendIdCustome, checkpoint)
+	} else {
+		err = writeRecordsBySeekMethodForCsv(app, id, writer, row, hasTable, index, fields, index == 0, isAppendIdCustome, checkpoint)
+	}
+	if err != nil {
+		return err
+	}
+	if err := finishDownloads(); err != nil {
 		return err
 	}
-	return writeRecordsBySeekMethodForCsv(app, 0, writer, row, hasTable, 0, fields, true, isAppendIdCustome)
+	if err := flushWriter(writer); err != nil {
+		return err
+	}
+	return checkpoint.Done()
 }
 
 func exportRecordsWithQuery(app *kintone.App, fields []string, writer io.Writer) error {
@@ -168,13 +159,13 @@ func exportRecords(app *kintone.App, fields []string, writer io.Writer) error {
 		return err
 	}
 	checkNoRecord(records)
-	if config.Format == "json" {
-		fmt.Fprint(writer, "{\"records\": [\n")
+	if isJSONFormat() {
+		writeHeaderJSON(writer)
 		_, err = writeRecordsJSON(app, writer, records, 0, false)
 		if err != nil {
 			return err
 		}
-		fmt.Fprint(writer, "\n]}")
+		writeFooterJSON(writer)
 	} else {
 		row, err := getRow(app)
 		hasTable := hasSubTable(row)
@@ -192,14 +183,20 @@ func exportRecords(app *kintone.App, fields []string, writer io.Writer) error {
 		return err
 	}
 
-	return nil
+	return finishDownloads()
 }
 
 func exportRecordsByCursor(app *kintone.App, fields []string, writer io.Writer) error {
-	if config.Format == "json" {
-		return exportRecordsByCursorForJSON(app, fields, writer)
+	var err error
+	if isJSONFormat() {
+		err = exportRecordsByCursorForJSON(app, fields, writer)
+	} else {
+		err = exportRecordsByCursorForCsv(app, fields, writer)
 	}
-	return exportRecordsByCursorForCsv(app, fields, writer)
+	if err != nil {
+		return err
+	}
+	return finishDownloads()
 }
 
 func exportRecordsByCursorForJSON(app *kintone.App, fields []string, writer io.Writer) error {
@@ -207,14 +204,14 @@ func exportRecordsByCursorForJSON(app *kintone.App, fields []string, writer io.W
 	if err != nil {
 		return err
 	}
-	index := uint64(0)
+	var index uint64
 	for {
 		recordsCursor, err := getAllRecordsByCursor(app, cursor.Id)
 		if err != nil {
 			return err
 		}
 		if index == 0 {
-			fmt.Fprint(writer, "{\"records\": [\n")
+			writeHeaderJSON(writer)
 		}
 		index, err = writeRecordsJSON(app, writer, recordsCursor.Records, index, false)
 		if err != nil {
@@ -222,7 +219,7 @@ func exportRecordsByCursorForJSON(app *kintone.App, fields []string, writer io.W
 		}
 
 		if !recordsCursor.Next {
-			fmt.Fprint(writer, "\n]}")
+			writeFooterJSON(writer)
 			break
 		}
 	}
@@ -241,7 +238,7 @@ func exportRecordsByCursorForCsv(app *kintone.App, fields []string, writer io.Wr
 		return err
 	}
 	hasTable := hasSubTable(row)
-	index := uint64(0)
+	var index uint64
 	for {
 		recordsCursor, err := getAllRecordsByCursor(app, cursor.Id)
 		if err != nil {
@@ -326,26 +323,6 @@ func getType(f interface{}) string {
 	return ""
 }
 
-func getUniqueFileName(filename, dir string) string {
-	filenameOuput := filename
-	fileExt := filepath.Ext(filename)
-	fileBaseName := filename[0 : len(filename)-len(fileExt)]
-	index := 0
-	parentDir := fmt.Sprintf("%s%c", dir, os.PathSeparator)
-	if dir == "" {
-		parentDir = ""
-	}
-	for {
-		fileFullPath := fmt.Sprintf("%s%s", parentDir, filenameOuput)
-		if !isExistFile(fileFullPath) {
-			break
-		}
-		index++
-		filenameOuput = fmt.Sprintf("%s (%d)%s", fileBaseName, index, fileExt)
-	}
-	return filenameOuput
-}
-
 func getSubTableRowCount(record *kintone.Record, row []*Cell) int {
 	var ret = 1
 	for _, cell := range row {
@@ -367,7 +344,49 @@ func getWriter(writer io.Writer) io.Writer {
 	if encoding == nil {
 		return writer
 	}
-	return transform.NewWriter(writer, encoding.NewEncoder())
+	return &encodingWriter{writer: transform.NewWriter(writer, encoding.NewEncoder())}
+}
+
+// encodingWriter the writer of "-e", which gives only complete characters to the encoder,
+// so that the encoder keeps no byte when the size of the output is saved to the checkpoint
+type encodingWriter struct {
+	writer *transform.Writer
+	// the first bytes of a character cut by the last write
+	partial []byte
+}
+
+func (w *encodingWriter) Write(p []byte) (int, error) {
+	data := append(w.partial, p...)
+	n := len(data)
+	for start := n - 1; start >= 0 && start >= n-utf8.UTFMax; start-- {
+		if utf8.RuneStart(data[start]) {
+			if !utf8.FullRune(data[start:]) {
+				n = start
+			}
+			break
+		}
+	}
+	if _, err := w.writer.Write(data[:n]); err != nil {
+		return 0, err
+	}
+	w.partial = append([]byte{}, data[n:]...)
+	return len(p), nil
+}
+
+// Flush writes the bytes kept by the encoder, the output must end with a complete character
+func (w *encodingWriter) Flush() error {
+	if len(w.partial) > 0 {
+		return fmt.Errorf("The output ends in the middle of a character: %q", w.partial)
+	}
+	return w.writer.Close()
+}
+
+// flushWriter writes the bytes kept by the encoder of "-e", if any
+func flushWriter(writer io.Writer) error {
+	if encoder, ok := writer.(*encodingWriter); ok {
+		return encoder.Flush()
+	}
+	return nil
 }
 
 func hasSubTable(row []*Cell) bool {
@@ -470,9 +489,28 @@ func writeHeaderCsv(writer io.Writer, hasTable bool, row Row) {
 	fmt.Fprint(writer, "\r\n")
 }
 
+// isJSONFormat reports whether the records are written as JSON ('json' or 'jsonl')
+func isJSONFormat() bool {
+	return config.Format == "json" || config.Format == "jsonl"
+}
+
+func writeHeaderJSON(writer io.Writer) {
+	if config.Format == "jsonl" {
+		return
+	}
+	fmt.Fprint(writer, "{\"records\": [\n")
+}
+
+func writeFooterJSON(writer io.Writer) {
+	if config.Format == "jsonl" {
+		return
+	}
+	fmt.Fprint(writer, "\n]}")
+}
+
 func writeRecordsJSON(app *kintone.App, writer io.Writer, records []*kintone.Record, i uint64, isAppendIdCustome bool) (uint64, error) {
 	for _, record := range records {
-		if i > 0 {
+		if i > 0 && config.Format != "jsonl" {
 			fmt.Fprint(writer, ",\n")
 		}
 		rowID := record.Id()
@@ -484,7 +522,7 @@ func writeRecordsJSON(app *kintone.App, writer io.Writer, records []*kintone.Rec
 			fieldType := reflect.TypeOf(fieldInfo).String()
 			if fieldType == "kintone.FileField" {
 				dir := fmt.Sprintf("%s-%d", fieldCode, rowID)
-				err := downloadFile(app, fieldInfo, dir)
+				err := downloadFile(app, fieldInfo, dir, record.Id(), fieldCode, 0)
 				if err != nil {
 					return 0, err
 
@@ -495,7 +533,7 @@ func writeRecordsJSON(app *kintone.App, writer io.Writer, records []*kintone.Rec
 					for fieldCodeInSubTable, fieldValueInSubTable := range subTableValue.Fields {
 						if reflect.TypeOf(fieldValueInSubTable).String() == "kintone.FileField" {
 							dir := fmt.Sprintf("%s-%d-%d", fieldCodeInSubTable, rowID, subTableIndex)
-							err := downloadFile(app, fieldValueInSubTable, dir)
+							err := downloadFile(app, fieldValueInSubTable, dir, record.Id(), fieldCodeInSubTable, subTableValue.Id())
 							if err != nil {
 								return 0, err
 
@@ -505,17 +543,47 @@ func writeRecordsJSON(app *kintone.App, writer io.Writer, records []*kintone.Rec
 				}
 			}
 		}
-		jsonArray, _ := record.MarshalJSON()
-		json := string(jsonArray)
-		_, err := fmt.Fprint(writer, json)
+		jsonArray, err := marshalRecordJSON(record, config.WithID && !isAppendIdCustome)
 		if err != nil {
 			return 0, err
 		}
+		_, err = fmt.Fprint(writer, string(jsonArray))
+		if err != nil {
+			return 0, err
+		}
+		if config.Format == "jsonl" {
+			// one record per line
+			fmt.Fprint(writer, "\n")
+		}
 		i++
 	}
 	return i, nil
 }
 
+// marshalRecordJSON marshals the record and, when withID is set, adds "$id" and
+// "$revision" so that the exported document can be imported again
+func marshalRecordJSON(record *kintone.Record, withID bool) ([]byte, error) {
+	data, err := record.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	if !withID || record.Id() == 0 {
+		return data, nil
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	if _, ok := fields["$id"]; !ok {
+		fields["$id"], _ = json.Marshal(map[string]string{"type": kintone.FT_ID, "value": strconv.FormatUint(record.Id(), 10)})
+	}
+	if _, ok := fields["$revision"]; !ok && record.Revision() > 0 {
+		fields["$revision"], _ = json.Marshal(map[string]string{"type": kintone.FT_REVISION, "value": strconv.FormatInt(record.Revision(), 10)})
+	}
+	return json.Marshal(fields)
+}
+
 func writeRecordsCsv(app *kintone.App, writer io.Writer, records []*kintone.Record, row Row, hasTable bool, i uint64, isAppendIdCustome bool) (uint64, error) {
 	if i == 0 {
 		writeHeaderCsv(writer, hasTable, row)
@@ -557,7 +625,7 @@ func writeRecordsCsv(app *kintone.App, writer io.Writer, records []*kintone.Reco
 						subField := table[j].Fields[f.Code]
 						if f.Type == kintone.FT_FILE {
 							dir := fmt.Sprintf("%s-%d-%d", f.Code, rowID, j)
-							err := downloadFile(app, subField, dir)
+							err := downloadFile(app, subField, dir, record.Id(), f.Code, table[j].Id())
 							if err != nil {
 								return 0, err
 							}
@@ -574,7 +642,7 @@ func writeRecordsCsv(app *kintone.App, writer io.Writer, records []*kintone.Reco
 					if field != nil {
 						if j == 0 && f.Type == kintone.FT_FILE {
 							dir := fmt.Sprintf("%s-%d", f.Code, rowID)
-							err := downloadFile(app, field, dir)
+							err := downloadFile(app, field, dir, record.Id(), f.Code, 0)
 							if err != nil {
 								return 0, err
 							}
@@ -598,7 +666,7 @@ func writeRecordsCsv(app *kintone.App, writer io.Writer, records []*kintone.Reco
 	return i, nil
 }
 
-func writeRecordsBySeekMethodForCsv(app *kintone.App, id uint64, writer io.Writer, row Row, hasTable bool, index uint64, fields []string, isRecordFound bool, isAppendIdCustome bool) error {
+func writeRecordsBySeekMethodForCsv(app *kintone.App, id uint64, writer io.Writer, row Row, hasTable bool, index uint64, fields []string, isRecordFound bool, isAppendIdCustome bool, checkpoint *ExportCheckpoint) error {
 	records, err := getRecordsForSeekMethod(app, id, fields, isRecordFound)
 	if err != nil {
 		return err
@@ -607,30 +675,40 @@ func writeRecordsBySeekMethodForCsv(app *kintone.App, id uint64, writer io.Write
 	if err != nil {
 		return err
 	}
+	if len(records) > 0 {
+		if err := checkpoint.Save(records[len(records)-1].Id(), index); err != nil {
+			return err
+		}
+	}
 	if len(records) == EXPORT_ROW_LIMIT {
 		isRecordFound = false
-		return writeRecordsBySeekMethodForCsv(app, records[len(records)-1].Id(), writer, row, hasTable, index, fields, isRecordFound, isAppendIdCustome)
+		return writeRecordsBySeekMethodForCsv(app, records[len(records)-1].Id(), writer, row, hasTable, index, fields, isRecordFound, isAppendIdCustome, checkpoint)
 	}
 	return nil
 }
 
-func writeRecordsBySeekMethodForJson(app *kintone.App, id uint64, writer io.Writer, index uint64, fields []string, isRecordsNotFound bool, isAppendIdCustome bool) error {
+func writeRecordsBySeekMethodForJson(app *kintone.App, id uint64, writer io.Writer, index uint64, fields []string, isRecordsNotFound bool, isAppendIdCustome bool, checkpoint *ExportCheckpoint) error {
 	records, err := getRecordsForSeekMethod(app, id, fields, isRecordsNotFound)
 	if err != nil {
 		return err
 	}
 	if index == 0 {
-		fmt.Fprint(writer, "{\"records\": [\n")
+		writeHeaderJSON(writer)
 	}
 	index, err = writeRecordsJSON(app, writer, records, index, isAppendIdCustome)
 	if err != nil {
 		return err
 	}
+	if len(records) > 0 {
+		if err := checkpoint.Save(records[len(records)-1].Id(), index); err != nil {
+			return err
+		}
+	}
 	if len(records) == EXPORT_ROW_LIMIT {
 		isRecordsNotFound = false
-		return writeRecordsBySeekMethodForJson(app, records[len(records)-1].Id(), writer, index, fields, isRecordsNotFound, isAppendIdCustome)
+		return writeRecordsBySeekMethodForJson(app, records[len(records)-1].Id(), writer, index, fields, isRecordsNotFound, isAppendIdCustome, checkpoint)
 	}
-	fmt.Fprint(writer, "\n]}")
+	writeFooterJSON(writer)
 	return nil
 }
 
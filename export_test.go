@@ -46,7 +46,10 @@ func makeTestData(app *kintone.App) error {
 }
 
 func TestSeekMethod(t *testing.T) {
-	app := newApp()
+	app := newApp(t)
+	if err := makeTestData(app); err != nil {
+		t.Fatal(err)
+	}
 	config.Query = ""
 	_, err := getRecordsForSeekMethod(app, 0, nil, true)
 	if err != nil {
@@ -56,7 +59,10 @@ func TestSeekMethod(t *testing.T) {
 }
 
 func TestGetRecordsHaveLimitOffset(t *testing.T) {
-	app := newApp()
+	app := newApp(t)
+	if err := makeTestData(app); err != nil {
+		t.Fatal(err)
+	}
 	buf := &bytes.Buffer{}
 	config.Query = "limit 100 offset 0"
 	err := exportRecords(app, nil, buf)
@@ -67,7 +73,10 @@ func TestGetRecordsHaveLimitOffset(t *testing.T) {
 }
 
 func TestGetRecordsHaveQuery(t *testing.T) {
-	app := newApp()
+	app := newApp(t)
+	if err := makeTestData(app); err != nil {
+		t.Fatal(err)
+	}
 	buf := &bytes.Buffer{}
 	config.Query = "order by $id desc"
 	err := exportRecordsByCursor(app, nil, buf)
@@ -79,10 +88,14 @@ func TestGetRecordsHaveQuery(t *testing.T) {
 func TestExport1(t *testing.T) {
 	buf := &bytes.Buffer{}
 
-	app := newApp()
-	makeTestData(app)
+	app := newApp(t)
+	if err := makeTestData(app); err != nil {
+		t.Fatal(err)
+	}
 
 	fields := []string{"single_line_text", "multi_line_text", "number"}
+	config.Fields = fields
+	defer func() { config.Fields = nil }()
 	config.Query = "order by record_number asc"
 
 	err := exportRecords(app, fields, buf)
@@ -148,8 +161,10 @@ func TestExport1(t *testing.T) {
 func TestExport2(t *testing.T) {
 	buf := &bytes.Buffer{}
 
-	app := newApp()
-	makeTestData(app)
+	app := newApp(t)
+	if err := makeTestData(app); err != nil {
+		t.Fatal(err)
+	}
 
 	config.Query = "order by record_number asc"
 	err := exportRecords(app, nil, buf)
@@ -157,108 +172,50 @@ func TestExport2(t *testing.T) {
 		t.Error(err)
 	}
 
-	//output := buf.String()
-	//fmt.Printf(output)
-
 	reader := csv.NewReader(buf)
 
-	row, err := reader.Read()
+	header, err := reader.Read()
 	if err != nil {
-		t.Error(err)
-	}
-	//fmt.Printf(row[0])
-	if row[0] != "*" {
-		t.Error("Invalid field code")
-	}
-	if row[1] != "single_line_text" {
-		t.Error("Invalid field code")
-	}
-	if row[2] != "multi_line_text" {
-		t.Error("Invalid field code")
-	}
-	if row[3] != "number" {
-		t.Error("Invalid field code")
-	}
-	if row[4] != "table" {
-		t.Error("Invalid field code")
-	}
-	if row[5] != "table_single_line_text" {
-		t.Error("Invalid field code")
+		t.Fatal(err)
 	}
-	if row[6] != "table_multi_line_text" {
+	if header[0] != "*" {
 		t.Error("Invalid field code")
 	}
-
-	row, err = reader.Read()
-	if err != nil {
-		t.Error(err)
-	}
-	if row[0] != "*" {
-		t.Error("Invalid 1st field value of row 1")
-	}
-	if row[1] != "single line1" {
-		t.Error("Invalid 2nd field value of row 1")
-	}
-	if row[2] != "multi line1\nmulti line" {
-		t.Error("Invalid 3rd field value of row 1")
-	}
-	if row[3] != "12345" {
-		t.Error("Invalid 4th field value of row 1")
-	}
-	if row[5] != "table single line1" {
-		t.Error("Invalid 5th field value of row 1")
-	}
-	if row[6] != "table multi line1\nmulti line" {
-		t.Error("Invalid 6th field value of row 1")
-	}
-
-	row, err = reader.Read()
-	if err != nil {
-		t.Error(err)
-	}
-	if row[0] != "" {
-		t.Error("Invalid 1st field value of row 2")
-	}
-	if row[1] != "single line1" {
-		t.Error("Invalid 2nd field value of row 2")
-	}
-	if row[2] != "multi line1\nmulti line" {
-		t.Error("Invalid 3rd field value of row 2")
-	}
-	if row[3] != "12345" {
-		t.Error("Invalid 4th field value of row 2")
-	}
-	if row[5] != "table single line2" {
-		t.Error("Invalid 5th field value of row 2")
-	}
-	if row[6] != "table multi line2\nmulti line" {
-		t.Error("Invalid 6th field value of row 2")
-	}
-
-	row, err = reader.Read()
-	if err != nil {
-		t.Error(err)
-	}
-	if row[0] != "*" {
-		t.Error("Invalid 1st field value of row 3")
-	}
-	if row[1] != "single line2" {
-		t.Error("Invalid 2nd field value of row 3")
-	}
-	if row[2] != "multi line2\nmulti line" {
-		t.Error("Invalid 3rd field value of row 3")
-	}
-	if row[3] != "12345" {
-		t.Error("Invalid 4th field value of row 3")
-	}
-	if row[5] != "" {
-		t.Error("Invalid 5th field value of row 3")
-	}
-	if row[6] != "" {
-		t.Error("Invalid 6th field value of row 3")
-	}
-
-	row, err = reader.Read()
+	// the columns are found by their field codes, in the order of the form
+	column := make(map[string]int)
+	for i, code := range header {
+		column[code] = i
+	}
+	previous := 0
+	for _, code := range []string{"single_line_text", "multi_line_text", "number", "table", "table_single_line_text", "table_multi_line_text"} {
+		index, ok := column[code]
+		if !ok || index <= previous {
+			t.Fatalf("Invalid field code %s in %v", code, header)
+		}
+		previous = index
+	}
+
+	expected := []map[string]string{
+		{"*": "*", "single_line_text": "single line1", "multi_line_text": "multi line1\nmulti line", "number": "12345",
+			"table_single_line_text": "table single line1", "table_multi_line_text": "table multi line1\nmulti line"},
+		{"*": "", "single_line_text": "single line1", "multi_line_text": "multi line1\nmulti line", "number": "12345",
+			"table_single_line_text": "table single line2", "table_multi_line_text": "table multi line2\nmulti line"},
+		{"*": "*", "single_line_text": "single line2", "multi_line_text": "multi line2\nmulti line", "number": "12345",
+			"table_single_line_text": "", "table_multi_line_text": ""},
+	}
+	for i, values := range expected {
+		row, err := reader.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for code, value := range values {
+			if row[column[code]] != value {
+				t.Errorf("Invalid value of %s of row %d: %q", code, i+1, row[column[code]])
+			}
+		}
+	}
+
+	_, err = reader.Read()
 	if err != io.EOF {
 		t.Error("Invalid record count")
 	}
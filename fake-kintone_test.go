@@ -0,0 +1,1712 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// FAKE_KINTONE_DOMAIN the domain of the apps of the fake kintone, the requests are sent to the test server instead
+const FAKE_KINTONE_DOMAIN = "example.cybozu.com"
+
+var fakeKintonePath = regexp.MustCompile(`^/k/(?:guest/(\d+)/)?v1/(.+)\.json$`)
+
+// fakeKintone a kintone server for the tests, which keeps the apps, their records and the files in memory.
+// It implements records.json, record.json, records/cursor.json, bulkRequest.json, file.json,
+// app/form/fields.json and app/form/layout.json, with the validation and the error responses of kintone.
+type fakeKintone struct {
+	server  *httptest.Server
+	mutex   sync.Mutex
+	apps    map[uint64]*fakeApp
+	cursors map[string]*fakeCursor
+	uploads map[string]*fakeFile
+	files   map[string]*fakeFile
+	serial  uint64
+	// the uploaded files attached to the record being written
+	attached []string
+
+	// the login name and the password of the password authentication
+	User     string
+	Password string
+	// the requests received, "METHOD /path"
+	Requests []string
+	// the error statuses returned to the next requests of "METHOD /path" instead of handling them,
+	// with a text body like the errors of a proxy or a load balancer
+	Failures map[string][]int
+	// the error statuses returned to the next requests of "METHOD /path" after handling them,
+	// like a response lost after kintone processed the request
+	Lost map[string][]int
+}
+
+// fakeApp an app of the fake kintone
+type fakeApp struct {
+	ID           uint64
+	GuestSpaceID uint64
+	APITokens    []string
+	// the fields in the order of the form
+	Fields []*kintone.FieldInfo
+
+	records []fakeRecord
+	lastID  uint64
+}
+
+// fakeRecord a record in the format of the responses, the code of the field => its type and value.
+// A record is never modified but replaced, so that a bulkRequest can be rolled back.
+type fakeRecord map[string]*fakeField
+
+type fakeField struct {
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+type fakeRow struct {
+	ID    string     `json:"id"`
+	Value fakeRecord `json:"value"`
+}
+
+type fakeEntity struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
+
+type fakeFileValue struct {
+	ContentType string `json:"contentType"`
+	FileKey     string `json:"fileKey"`
+	Name        string `json:"name"`
+	Size        string `json:"size"`
+}
+
+type fakeFile struct {
+	Name        string
+	ContentType string
+	Data        []byte
+}
+
+type fakeCursor struct {
+	records []fakeRecord
+	size    int
+}
+
+// fakeError an error response of kintone
+type fakeError struct {
+	status  int
+	Code    string                 `json:"code"`
+	ID      string                 `json:"id"`
+	Message string                 `json:"message"`
+	Errors  map[string]interface{} `json:"errors,omitempty"`
+	// the response instead of the error, the results of a bulkRequest
+	body interface{}
+}
+
+func (e *fakeError) Error() string {
+	return fmt.Sprintf("[%d] %s %s", e.status, e.Code, e.Message)
+}
+
+func newFakeError(status int, code, message string) *fakeError {
+	return &fakeError{status: status, Code: code, ID: fmt.Sprintf("fake-%d", time.Now().UnixNano()), Message: message}
+}
+
+// newInputError the error of the invalid values, the messages of the key are added by add
+func newInputError() *fakeError {
+	return newFakeError(http.StatusBadRequest, "CB_VA01", "Missing or invalid input.")
+}
+
+func (e *fakeError) add(key, message string) {
+	if e.Errors == nil {
+		e.Errors = map[string]interface{}{}
+	}
+	messages, _ := e.Errors[key].(map[string]interface{})
+	if messages == nil {
+		messages = map[string]interface{}{"messages": []string{}}
+		e.Errors[key] = messages
+	}
+	messages["messages"] = append(messages["messages"].([]string), message)
+}
+
+func (e *fakeError) orNil() *fakeError {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e
+}
+
+// newFakeKintone starts a fake kintone with the apps, it is closed at the end of the test
+func newFakeKintone(t *testing.T, apps ...*fakeApp) *fakeKintone {
+	fake := &fakeKintone{
+		apps:     map[uint64]*fakeApp{},
+		cursors:  map[string]*fakeCursor{},
+		uploads:  map[string]*fakeFile{},
+		files:    map[string]*fakeFile{},
+		User:     "user",
+		Password: "password",
+	}
+	for _, app := range apps {
+		fake.apps[app.ID] = app
+	}
+	fake.server = httptest.NewServer(fake)
+	t.Cleanup(fake.server.Close)
+	return fake
+}
+
+// App the client of the app with its first API token, or with the password if it has no API token
+func (fake *fakeKintone) App(id uint64) *kintone.App {
+	baseURL, _ := url.Parse(fake.server.URL)
+	app := &kintone.App{
+		Domain: FAKE_KINTONE_DOMAIN,
+		AppId:  id,
+		Client: &http.Client{Transport: &BaseURLTransport{Transport: http.DefaultTransport, BaseURL: baseURL}},
+	}
+	if fakeApp, ok := fake.apps[id]; ok {
+		app.GuestSpaceId = fakeApp.GuestSpaceID
+		if len(fakeApp.APITokens) > 0 {
+			app.ApiToken = fakeApp.APITokens[0]
+			return app
+		}
+	}
+	app.User = fake.User
+	app.Password = fake.Password
+	return app
+}
+
+// Records the records of the app, from the smallest $id
+func (fake *fakeKintone) Records(id uint64) []fakeRecord {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	return append([]fakeRecord(nil), fake.apps[id].records...)
+}
+
+func (fake *fakeKintone) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fake.mutex.Lock()
+	defer fake.mutex.Unlock()
+	fake.Requests = append(fake.Requests, r.Method+" "+r.URL.Path)
+	if statuses := fake.Failures[r.Method+" "+r.URL.Path]; len(statuses) > 0 {
+		fake.Failures[r.Method+" "+r.URL.Path] = statuses[1:]
+		http.Error(w, http.StatusText(statuses[0]), statuses[0])
+		return
+	}
+
+	result, err := fake.handle(r)
+	if statuses := fake.Lost[r.Method+" "+r.URL.Path]; len(statuses) > 0 {
+		fake.Lost[r.Method+" "+r.URL.Path] = statuses[1:]
+		http.Error(w, http.StatusText(statuses[0]), statuses[0])
+		return
+	}
+	if err != nil && err.body != nil {
+		writeFakeJSON(w, err.status, err.body)
+		return
+	}
+	if err != nil {
+		writeFakeJSON(w, err.status, err)
+		return
+	}
+	if file, ok := result.(*fakeFile); ok {
+		w.Header().Set("Content-Type", file.ContentType)
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": file.Name}))
+		w.Write(file.Data)
+		return
+	}
+	writeFakeJSON(w, http.StatusOK, result)
+}
+
+func writeFakeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+func (fake *fakeKintone) handle(r *http.Request) (interface{}, *fakeError) {
+	match := fakeKintonePath.FindStringSubmatch(r.URL.Path)
+	if match == nil {
+		return nil, newFakeError(http.StatusNotFound, "CB_NO02", "The API does not exist.")
+	}
+	guestSpaceID, _ := strconv.ParseUint(match[1], 10, 64)
+	api := match[2]
+
+	if api == "file" && r.Method == "POST" {
+		if err := fake.authenticate(r, nil); err != nil {
+			return nil, err
+		}
+		return fake.upload(r)
+	}
+	params, err := parseFakeParams(r)
+	if err != nil {
+		return nil, err
+	}
+	if api == "bulkRequest" {
+		if r.Method != "POST" {
+			return nil, newFakeError(http.StatusMethodNotAllowed, "CB_NO02", "The API does not exist.")
+		}
+		return fake.bulkRequest(r, params)
+	}
+	saved := fake.save()
+	result, err := fake.call(r, r.Method, guestSpaceID, api, params)
+	if err != nil {
+		// the file keys are used only by the requests which succeed
+		fake.restore(saved)
+	}
+	return result, err
+}
+
+// call runs an API, alone or in a bulkRequest
+func (fake *fakeKintone) call(r *http.Request, method string, guestSpaceID uint64, api string, params fakeParams) (interface{}, *fakeError) {
+	switch api + " " + method {
+	case "records/cursor GET", "records/cursor DELETE":
+		if err := fake.authenticate(r, nil); err != nil {
+			return nil, err
+		}
+		return fake.cursor(method, params)
+	case "file GET":
+		if err := fake.authenticate(r, nil); err != nil {
+			return nil, err
+		}
+		file, ok := fake.files[params.String("fileKey")]
+		if !ok {
+			return nil, newFakeError(http.StatusNotFound, "GAIA_BL01", "The specified file (fileKey: "+params.String("fileKey")+") not found.")
+		}
+		return file, nil
+	}
+
+	app, err := fake.getApp(params, guestSpaceID)
+	if err != nil {
+		return nil, err
+	}
+	if err := fake.authenticate(r, app); err != nil {
+		return nil, err
+	}
+	switch api + " " + method {
+	case "records GET":
+		return fake.getRecords(app, params)
+	case "records POST":
+		return fake.addRecords(r, app, params)
+	case "records PUT":
+		return fake.updateRecords(r, app, params)
+	case "records DELETE":
+		return fake.deleteRecords(app, params)
+	case "record GET":
+		record, err := app.find(params.Uint("id"))
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"record": record}, nil
+	case "record POST":
+		result, err := fake.addRecords(r, app, fakeParams{"records": mustMarshal([]json.RawMessage{params["record"]})})
+		if err != nil {
+			return nil, renameFakeErrors(err, "records[0].", "record.")
+		}
+		return map[string]string{"id": result["ids"][0], "revision": result["revisions"][0]}, nil
+	case "record PUT":
+		params["records"] = mustMarshal([]fakeParams{params})
+		result, err := fake.updateRecords(r, app, params)
+		if err != nil {
+			return nil, renameFakeErrors(err, "records[0].", "")
+		}
+		return map[string]string{"revision": result["records"][0]["revision"]}, nil
+	case "records/cursor POST":
+		return fake.createCursor(app, params)
+	case "app/form/fields GET":
+		return map[string]interface{}{"properties": app.properties(), "revision": "1"}, nil
+	case "app/form/layout GET":
+		return map[string]interface{}{"layout": app.layout(), "revision": "1"}, nil
+	}
+	return nil, newFakeError(http.StatusNotFound, "CB_NO02", "The API does not exist.")
+}
+
+// authenticate checks the API token of the app, or the password.
+// The API tokens of several apps can be specified, separated by commas.
+func (fake *fakeKintone) authenticate(r *http.Request, app *fakeApp) *fakeError {
+	if tokens := r.Header.Get("X-Cybozu-API-Token"); tokens != "" {
+		valid := false
+		for _, token := range strings.Split(tokens, ",") {
+			for _, other := range fake.apps {
+				for _, appToken := range other.APITokens {
+					if token != appToken {
+						continue
+					}
+					if app == nil || other == app {
+						return nil
+					}
+					valid = true
+				}
+			}
+		}
+		if !valid {
+			return newFakeError(http.StatusUnauthorized, "GAIA_IA02", "The specified API token does not match the API token generated via an app.")
+		}
+		return newFakeError(http.StatusForbidden, "GAIA_NO01", "Using this API token, you cannot run the specified API.")
+	}
+	if authorization := r.Header.Get("X-Cybozu-Authorization"); authorization != "" {
+		credentials, _ := base64.StdEncoding.DecodeString(authorization)
+		if string(credentials) == fake.User+":"+fake.Password {
+			return nil
+		}
+		return newFakeError(http.StatusUnauthorized, "CB_WA01", "Password authentication failed.")
+	}
+	return newFakeError(http.StatusUnauthorized, "CB_AU01", "Please login.")
+}
+
+func (fake *fakeKintone) getApp(params fakeParams, guestSpaceID uint64) (*fakeApp, *fakeError) {
+	if _, ok := params["app"]; !ok {
+		err := newInputError()
+		err.add("app", "Required field.")
+		return nil, err
+	}
+	id := params.Uint("app")
+	app, ok := fake.apps[id]
+	if !ok || (guestSpaceID != 0 && guestSpaceID != app.GuestSpaceID) {
+		return nil, newFakeError(http.StatusNotFound, "GAIA_AP01", fmt.Sprintf("The app (ID: %d) not found. The app may have been deleted.", id))
+	}
+	if guestSpaceID != app.GuestSpaceID {
+		return nil, newFakeError(http.StatusBadRequest, "GAIA_IL23", "Specify the guest space ID in the request URL to run an API in the guest space.")
+	}
+	return app, nil
+}
+
+func (app *fakeApp) field(code string) *kintone.FieldInfo {
+	for _, field := range app.Fields {
+		if field.Code == code {
+			return field
+		}
+	}
+	return nil
+}
+
+func (app *fakeApp) find(id uint64) (fakeRecord, *fakeError) {
+	for _, record := range app.records {
+		if record.id() == id {
+			return record, nil
+		}
+	}
+	return nil, newFakeError(http.StatusNotFound, "GAIA_RE01", fmt.Sprintf("The specified record (ID: %d) is not found.", id))
+}
+
+func (record fakeRecord) id() uint64 {
+	id, _ := strconv.ParseUint(record["$id"].Value.(string), 10, 64)
+	return id
+}
+
+func (record fakeRecord) revision() uint64 {
+	revision, _ := strconv.ParseUint(record["$revision"].Value.(string), 10, 64)
+	return revision
+}
+
+// project the fields of the record in the response
+func (record fakeRecord) project(fields []string) fakeRecord {
+	if len(fields) == 0 {
+		return record
+	}
+	projected := fakeRecord{}
+	for _, code := range fields {
+		if field, ok := record[code]; ok {
+			projected[code] = field
+		}
+	}
+	return projected
+}
+
+func (fake *fakeKintone) getRecords(app *fakeApp, params fakeParams) (interface{}, *fakeError) {
+	query, err := parseFakeQuery(app, params.String("query"))
+	if err != nil {
+		return nil, err
+	}
+	if query.limit > 500 {
+		err := newInputError()
+		err.add("query", "limit must be 500 or less.")
+		return nil, err
+	}
+	if query.offset > 10000 {
+		err := newInputError()
+		err.add("query", "offset must be 10,000 or less.")
+		return nil, err
+	}
+	records := query.run(app)
+	count := len(records)
+	records = query.page(records, 100)
+	fields := params.Strings("fields")
+	response := make([]fakeRecord, 0, len(records))
+	for _, record := range records {
+		response = append(response, record.project(fields))
+	}
+	result := map[string]interface{}{"records": response, "totalCount": nil}
+	if params.String("totalCount") == "true" {
+		result["totalCount"] = strconv.Itoa(count)
+	}
+	return result, nil
+}
+
+func (fake *fakeKintone) addRecords(r *http.Request, app *fakeApp, params fakeParams) (map[string][]string, *fakeError) {
+	var inputs []json.RawMessage
+	if err := params.Decode("records", &inputs); err != nil || len(inputs) == 0 {
+		err := newInputError()
+		err.add("records", "Required field.")
+		return nil, err
+	}
+	if len(inputs) > 100 {
+		err := newInputError()
+		err.add("records", "Only 100 records can be added at once.")
+		return nil, err
+	}
+	result := map[string][]string{"ids": {}, "revisions": {}}
+	added := make([]fakeRecord, 0, len(inputs))
+	errs := newInputError()
+	lastID := app.lastID
+	for i, input := range inputs {
+		lastID++
+		record := fake.newRecord(app, lastID, userName(r))
+		record, err := fake.writeRecord(app, record, input, fmt.Sprintf("records[%d].", i), added, errs)
+		if err != nil {
+			return nil, err
+		}
+		added = append(added, record)
+		result["ids"] = append(result["ids"], strconv.FormatUint(lastID, 10))
+		result["revisions"] = append(result["revisions"], "1")
+	}
+	if err := errs.orNil(); err != nil {
+		return nil, err
+	}
+	app.records = append(app.records, added...)
+	app.lastID = lastID
+	return result, nil
+}
+
+func (fake *fakeKintone) updateRecords(r *http.Request, app *fakeApp, params fakeParams) (map[string][]map[string]string, *fakeError) {
+	var inputs []struct {
+		ID        json.RawMessage `json:"id"`
+		UpdateKey *struct {
+			Field string      `json:"field"`
+			Value interface{} `json:"value"`
+		} `json:"updateKey"`
+		Revision json.RawMessage `json:"revision"`
+		Record   json.RawMessage `json:"record"`
+	}
+	if err := params.Decode("records", &inputs); err != nil || len(inputs) == 0 {
+		err := newInputError()
+		err.add("records", "Required field.")
+		return nil, err
+	}
+	if len(inputs) > 100 {
+		err := newInputError()
+		err.add("records", "Only 100 records can be updated at once.")
+		return nil, err
+	}
+	result := map[string][]map[string]string{"records": {}}
+	updated := map[uint64]fakeRecord{}
+	errs := newInputError()
+	for i, input := range inputs {
+		prefix := fmt.Sprintf("records[%d].", i)
+		var old fakeRecord
+		switch {
+		case input.UpdateKey != nil:
+			field := app.field(input.UpdateKey.Field)
+			if field == nil || !field.Unique || (field.Type != kintone.FT_SINGLE_LINE_TEXT && field.Type != kintone.FT_DECIMAL) {
+				err := newInputError()
+				err.add(prefix+"updateKey.field", "The field must be a text or a number field which prohibits duplicate values.")
+				return nil, err
+			}
+			value := fmt.Sprint(input.UpdateKey.Value)
+			for _, record := range app.records {
+				if record[field.Code].Value == value {
+					old = record
+				}
+			}
+			if old == nil {
+				return nil, newFakeError(http.StatusNotFound, "GAIA_RE20", fmt.Sprintf("The record whose %s is %s is not found.", field.Code, value))
+			}
+		default:
+			id, _ := strconv.ParseUint(strings.Trim(string(input.ID), `"`), 10, 64)
+			var err *fakeError
+			if old, err = app.find(id); err != nil {
+				return nil, err
+			}
+		}
+		if record, ok := updated[old.id()]; ok {
+			old = record
+		}
+		if revision := strings.Trim(string(input.Revision), `"`); revision != "" && revision != "-1" && revision != "null" {
+			if revision != strconv.FormatUint(old.revision(), 10) {
+				return nil, newFakeError(http.StatusConflict, "GAIA_CO02", "The revision is not the latest. Someone may update a record.")
+			}
+		}
+		record := old.clone()
+		record["$revision"] = &fakeField{kintone.FT_REVISION, strconv.FormatUint(old.revision()+1, 10)}
+		fake.setModifier(app, record, userName(r))
+		others := make([]fakeRecord, 0, len(updated))
+		for _, other := range updated {
+			others = append(others, other)
+		}
+		record, err := fake.writeRecord(app, record, input.Record, prefix, others, errs)
+		if err != nil {
+			return nil, err
+		}
+		updated[record.id()] = record
+		result["records"] = append(result["records"], map[string]string{"id": strconv.FormatUint(record.id(), 10), "revision": record["$revision"].Value.(string)})
+	}
+	if err := errs.orNil(); err != nil {
+		return nil, err
+	}
+	for i, record := range app.records {
+		if replaced, ok := updated[record.id()]; ok {
+			app.records[i] = replaced
+		}
+	}
+	return result, nil
+}
+
+func (fake *fakeKintone) deleteRecords(app *fakeApp, params fakeParams) (interface{}, *fakeError) {
+	ids := params.Strings("ids")
+	if len(ids) == 0 {
+		err := newInputError()
+		err.add("ids", "Required field.")
+		return nil, err
+	}
+	if len(ids) > 100 {
+		err := newInputError()
+		err.add("ids", "Only 100 records can be deleted at once.")
+		return nil, err
+	}
+	deleted := map[uint64]bool{}
+	for _, value := range ids {
+		id, _ := strconv.ParseUint(value, 10, 64)
+		if _, err := app.find(id); err != nil {
+			return nil, err
+		}
+		deleted[id] = true
+	}
+	records := make([]fakeRecord, 0, len(app.records))
+	for _, record := range app.records {
+		if !deleted[record.id()] {
+			records = append(records, record)
+		}
+	}
+	app.records = records
+	return map[string]interface{}{}, nil
+}
+
+func (fake *fakeKintone) createCursor(app *fakeApp, params fakeParams) (interface{}, *fakeError) {
+	query, err := parseFakeQuery(app, params.String("query"))
+	if err != nil {
+		return nil, err
+	}
+	if query.limit >= 0 || query.offset > 0 {
+		err := newInputError()
+		err.add("query", "limit and offset cannot be specified in the query of a cursor.")
+		return nil, err
+	}
+	size := 100
+	if _, ok := params["size"]; ok {
+		size = int(params.Uint("size"))
+	}
+	if size < 1 || size > 500 {
+		err := newInputError()
+		err.add("size", "size must be between 1 and 500.")
+		return nil, err
+	}
+	if len(fake.cursors) >= 10 {
+		return nil, newFakeError(http.StatusBadRequest, "GAIA_TM01", "Cannot create more than 10 cursors at once.")
+	}
+	records := query.run(app)
+	fields := params.Strings("fields")
+	cursor := &fakeCursor{size: size}
+	for _, record := range records {
+		cursor.records = append(cursor.records, record.project(fields))
+	}
+	fake.serial++
+	id := fmt.Sprintf("fake-cursor-%d", fake.serial)
+	fake.cursors[id] = cursor
+	return map[string]string{"id": id, "totalCount": strconv.Itoa(len(records))}, nil
+}
+
+func (fake *fakeKintone) cursor(method string, params fakeParams) (interface{}, *fakeError) {
+	id := params.String("id")
+	cursor, ok := fake.cursors[id]
+	if !ok {
+		return nil, newFakeError(http.StatusNotFound, "GAIA_CN01", "The specified cursor does not exist.")
+	}
+	if method == "DELETE" {
+		delete(fake.cursors, id)
+		return map[string]interface{}{}, nil
+	}
+	records := cursor.records
+	if len(records) > cursor.size {
+		records = records[:cursor.size]
+	}
+	cursor.records = cursor.records[len(records):]
+	next := len(cursor.records) > 0
+	if !next {
+		delete(fake.cursors, id)
+	}
+	return map[string]interface{}{"records": append([]fakeRecord{}, records...), "next": next}, nil
+}
+
+// bulkRequest runs the requests in a transaction: all of them, or none of them if one fails
+func (fake *fakeKintone) bulkRequest(r *http.Request, params fakeParams) (interface{}, *fakeError) {
+	var requests []struct {
+		Method  string     `json:"method"`
+		API     string     `json:"api"`
+		Payload fakeParams `json:"payload"`
+	}
+	if err := params.Decode("requests", &requests); err != nil || len(requests) == 0 {
+		err := newInputError()
+		err.add("requests", "Required field.")
+		return nil, err
+	}
+	if len(requests) > 20 {
+		err := newInputError()
+		err.add("requests", "Only 20 requests can be sent at once.")
+		return nil, err
+	}
+
+	saved := fake.save()
+	results := make([]interface{}, len(requests))
+	for i := range results {
+		results[i] = map[string]interface{}{}
+	}
+	for i, request := range requests {
+		var result interface{}
+		var err *fakeError
+		match := fakeKintonePath.FindStringSubmatch(request.API)
+		switch {
+		case match == nil || match[2] == "bulkRequest" || match[2] == "file" || request.Method == "GET":
+			err = newFakeError(http.StatusBadRequest, "GAIA_BR01", fmt.Sprintf("The API %s %s cannot be run in a bulkRequest.", request.Method, request.API))
+		default:
+			guestSpaceID, _ := strconv.ParseUint(match[1], 10, 64)
+			result, err = fake.call(r, request.Method, guestSpaceID, match[2], request.Payload)
+		}
+		if err != nil {
+			fake.restore(saved)
+			results[i] = err
+			return nil, &fakeError{status: err.status, body: map[string]interface{}{"results": results}}
+		}
+		results[i] = result
+	}
+	return map[string]interface{}{"results": results}, nil
+}
+
+// fakeState the records of the apps and the uploaded files, restored when a request fails
+type fakeState struct {
+	records map[uint64][]fakeRecord
+	uploads map[string]*fakeFile
+}
+
+func (fake *fakeKintone) save() fakeState {
+	saved := fakeState{records: map[uint64][]fakeRecord{}, uploads: map[string]*fakeFile{}}
+	for id, app := range fake.apps {
+		saved.records[id] = append([]fakeRecord(nil), app.records...)
+	}
+	for key, file := range fake.uploads {
+		saved.uploads[key] = file
+	}
+	return saved
+}
+
+func (fake *fakeKintone) restore(saved fakeState) {
+	fake.uploads = saved.uploads
+	for id, records := range saved.records {
+		app := fake.apps[id]
+		app.records = records
+		app.lastID = 0
+		for _, record := range records {
+			if record.id() > app.lastID {
+				app.lastID = record.id()
+			}
+		}
+	}
+}
+
+func (fake *fakeKintone) upload(r *http.Request) (interface{}, *fakeError) {
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		err := newInputError()
+		err.add("file", "Required field.")
+		return nil, err
+	}
+	defer file.Close()
+	data, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, newFakeError(http.StatusBadRequest, "CB_IJ01", err.Error())
+	}
+	contentType := header.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	fake.serial++
+	key := fmt.Sprintf("fake-upload-%d", fake.serial)
+	fake.uploads[key] = &fakeFile{Name: header.Filename, ContentType: contentType, Data: data}
+	return map[string]string{"fileKey": key}, nil
+}
+
+func userName(r *http.Request) string {
+	if r.Header.Get("X-Cybozu-API-Token") != "" {
+		return "Administrator"
+	}
+	credentials, _ := base64.StdEncoding.DecodeString(r.Header.Get("X-Cybozu-Authorization"))
+	return strings.SplitN(string(credentials), ":", 2)[0]
+}
+
+// newRecord a record with the default values of the fields
+func (fake *fakeKintone) newRecord(app *fakeApp, id uint64, user string) fakeRecord {
+	record := fakeRecord{
+		"$id":       {kintone.FT_ID, strconv.FormatUint(id, 10)},
+		"$revision": {kintone.FT_REVISION, "1"},
+	}
+	for _, field := range app.Fields {
+		record[field.Code] = &fakeField{field.Type, defaultFakeValue(field)}
+		switch field.Type {
+		case kintone.FT_RECNUM:
+			record[field.Code].Value = strconv.FormatUint(id, 10)
+		case kintone.FT_CREATOR:
+			record[field.Code].Value = fakeEntity{user, user}
+		case kintone.FT_CTIME:
+			record[field.Code].Value = time.Now().UTC().Format("2006-01-02T15:04:00Z")
+		}
+	}
+	fake.setModifier(app, record, user)
+	return record
+}
+
+func (fake *fakeKintone) setModifier(app *fakeApp, record fakeRecord, user string) {
+	for _, field := range app.Fields {
+		switch field.Type {
+		case kintone.FT_MODIFIER:
+			record[field.Code] = &fakeField{field.Type, fakeEntity{user, user}}
+		case kintone.FT_MTIME:
+			record[field.Code] = &fakeField{field.Type, time.Now().UTC().Format("2006-01-02T15:04:00Z")}
+		}
+	}
+}
+
+func defaultFakeValue(field *kintone.FieldInfo) interface{} {
+	switch field.Type {
+	case kintone.FT_CHECK_BOX, kintone.FT_MULTI_SELECT, kintone.FT_CATEGORY:
+		return []string{}
+	case kintone.FT_USER, kintone.FT_ORGANIZATION, kintone.FT_GROUP, kintone.FT_ASSIGNEE:
+		return []fakeEntity{}
+	case kintone.FT_FILE:
+		return []fakeFileValue{}
+	case kintone.FT_SUBTABLE:
+		return []fakeRow{}
+	case kintone.FT_DATE, kintone.FT_TIME, kintone.FT_DATETIME, kintone.FT_SINGLE_SELECT:
+		return nil
+	case kintone.FT_RADIO:
+		if len(field.Options) > 0 {
+			return field.Options[0]
+		}
+	}
+	if value, ok := field.Default.(string); ok {
+		return value
+	}
+	return ""
+}
+
+func (record fakeRecord) clone() fakeRecord {
+	clone := fakeRecord{}
+	for code, field := range record {
+		clone[code] = &fakeField{field.Type, field.Value}
+	}
+	return clone
+}
+
+// writeRecord writes the values of the request to the record, and checks them.
+// The errors of the values are added to errs, the other records are checked for the unique fields.
+func (fake *fakeKintone) writeRecord(app *fakeApp, record fakeRecord, input json.RawMessage, prefix string, others []fakeRecord, errs *fakeError) (fakeRecord, *fakeError) {
+	var values map[string]struct {
+		Value json.RawMessage `json:"value"`
+	}
+	if err := json.Unmarshal(input, &values); err != nil {
+		return nil, newFakeError(http.StatusBadRequest, "CB_IJ01", "Invalid JSON string.")
+	}
+	// a file key of an upload is used by one record, in one or more fields
+	defer func() {
+		for _, fileKey := range fake.attached {
+			delete(fake.uploads, fileKey)
+		}
+		fake.attached = nil
+	}()
+	for code, value := range values {
+		field := app.field(code)
+		if field == nil {
+			// kintone ignores the unknown fields
+			continue
+		}
+		key := prefix + code + ".value"
+		if field.Type == kintone.FT_SUBTABLE {
+			old, _ := record[code].Value.([]fakeRow)
+			record[code] = &fakeField{field.Type, fake.writeTable(field, old, value.Value, key, errs)}
+			continue
+		}
+		if converted, ok := fake.convert(field, value.Value, key, errs); ok {
+			record[code] = &fakeField{field.Type, converted}
+		}
+	}
+
+	for _, field := range app.Fields {
+		if field.Required && isEmptyFakeValue(record[field.Code].Value) {
+			errs.add(prefix+field.Code+".value", "Required.")
+		}
+		if field.Type == kintone.FT_SUBTABLE {
+			for i, row := range record[field.Code].Value.([]fakeRow) {
+				for _, sub := range field.Fields {
+					if sub.Required && isEmptyFakeValue(row.Value[sub.Code].Value) {
+						errs.add(fmt.Sprintf("%s%s.value[%d].value.%s.value", prefix, field.Code, i, sub.Code), "Required.")
+					}
+				}
+			}
+		}
+		if field.Unique && !isEmptyFakeValue(record[field.Code].Value) {
+			for _, other := range append(append([]fakeRecord(nil), app.records...), others...) {
+				if other.id() != record.id() && other[field.Code].Value == record[field.Code].Value {
+					errs.add(prefix+field.Code+".value", "This value already exists in another record.")
+					break
+				}
+			}
+		}
+	}
+	return record, nil
+}
+
+func (fake *fakeKintone) writeTable(field *kintone.FieldInfo, old []fakeRow, input json.RawMessage, key string, errs *fakeError) []fakeRow {
+	var rows []struct {
+		ID    json.RawMessage `json:"id"`
+		Value map[string]struct {
+			Value json.RawMessage `json:"value"`
+		} `json:"value"`
+	}
+	if err := json.Unmarshal(input, &rows); err != nil {
+		errs.add(key, "The value of the table must be an array of the rows.")
+		return old
+	}
+	table := make([]fakeRow, 0, len(rows))
+	for i, input := range rows {
+		row := fakeRow{Value: fakeRecord{}}
+		for _, sub := range field.Fields {
+			sub := sub
+			row.Value[sub.Code] = &fakeField{sub.Type, defaultFakeValue(&sub)}
+		}
+		id := strings.Trim(string(input.ID), `"`)
+		for _, oldRow := range old {
+			if oldRow.ID == id {
+				row.Value = oldRow.Value.clone()
+				row.ID = id
+			}
+		}
+		if row.ID == "" {
+			fake.serial++
+			row.ID = strconv.FormatUint(fake.serial, 10)
+		}
+		for code, value := range input.Value {
+			for _, sub := range field.Fields {
+				if sub.Code != code {
+					continue
+				}
+				sub := sub
+				if converted, ok := fake.convert(&sub, value.Value, fmt.Sprintf("%s[%d].value.%s.value", key, i, code), errs); ok {
+					row.Value[code] = &fakeField{sub.Type, converted}
+				}
+			}
+		}
+		table = append(table, row)
+	}
+	return table
+}
+
+var fakeNumber = regexp.MustCompile(`^[+-]?(\d+\.?\d*|\.\d+)([eE][+-]?\d+)?$`)
+
+// convert the value of the request to the value of the field, or add its error to errs
+func (fake *fakeKintone) convert(field *kintone.FieldInfo, input json.RawMessage, key string, errs *fakeError) (interface{}, bool) {
+	var value interface{}
+	if err := json.Unmarshal(input, &value); err != nil {
+		errs.add(key, "Invalid value.")
+		return nil, false
+	}
+	invalid := func(message string) (interface{}, bool) {
+		errs.add(key, message)
+		return nil, false
+	}
+	inOptions := func(value string) bool {
+		for _, option := range field.Options {
+			if option == value {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch field.Type {
+	case kintone.FT_RECNUM, kintone.FT_CREATOR, kintone.FT_MODIFIER, kintone.FT_CTIME, kintone.FT_MTIME,
+		kintone.FT_CALC, kintone.FT_STATUS, kintone.FT_ASSIGNEE, kintone.FT_CATEGORY:
+		// the values of the fields are set by kintone
+		return nil, false
+	case kintone.FT_SINGLE_LINE_TEXT, kintone.FT_MULTI_LINE_TEXT, kintone.FT_RICH_TEXT, kintone.FT_LINK:
+		text, ok := fakeString(value)
+		if !ok {
+			return invalid("The value must be a string.")
+		}
+		if max, err := strconv.Atoi(fmt.Sprint(field.MaxLength)); err == nil && len([]rune(text)) > max {
+			return invalid(fmt.Sprintf("Enter %d or less characters.", max))
+		}
+		if field.Type == kintone.FT_SINGLE_LINE_TEXT && strings.ContainsAny(text, "\r\n") {
+			return invalid("Line breaks are not allowed.")
+		}
+		return text, true
+	case kintone.FT_DECIMAL:
+		number, ok := fakeString(value)
+		if !ok || (number != "" && !fakeNumber.MatchString(number)) {
+			return invalid("Only numbers are allowed.")
+		}
+		return number, true
+	case kintone.FT_RADIO, kintone.FT_SINGLE_SELECT:
+		option, ok := fakeString(value)
+		if !ok {
+			return invalid("The value must be a string.")
+		}
+		if option == "" {
+			return defaultFakeValue(field), true
+		}
+		if !inOptions(option) {
+			return invalid(fmt.Sprintf("The value, \"%s\", is not in options.", option))
+		}
+		return option, true
+	case kintone.FT_CHECK_BOX, kintone.FT_MULTI_SELECT:
+		options, ok := fakeStrings(value)
+		if !ok {
+			return invalid("The value must be an array.")
+		}
+		for _, option := range options {
+			if !inOptions(option) {
+				return invalid(fmt.Sprintf("The value, \"%s\", is not in options.", option))
+			}
+		}
+		return options, true
+	case kintone.FT_DATE, kintone.FT_TIME, kintone.FT_DATETIME:
+		text, ok := fakeString(value)
+		if !ok {
+			return invalid("The value must be a string.")
+		}
+		if text == "" {
+			return nil, true
+		}
+		switch field.Type {
+		case kintone.FT_DATE:
+			if _, err := time.Parse("2006-01-02", text); err != nil {
+				return invalid("Invalid date format.")
+			}
+			return text, true
+		case kintone.FT_TIME:
+			if _, err := time.Parse("15:04", text); err != nil {
+				return invalid("Invalid time format.")
+			}
+			return text, true
+		}
+		t, err := time.Parse(time.RFC3339, text)
+		if err != nil {
+			return invalid("Invalid datetime format.")
+		}
+		return t.UTC().Format("2006-01-02T15:04:00Z"), true
+	case kintone.FT_USER, kintone.FT_ORGANIZATION, kintone.FT_GROUP:
+		var entities []fakeEntity
+		if err := json.Unmarshal(input, &entities); err != nil {
+			return invalid("The value must be an array of the codes.")
+		}
+		for i := range entities {
+			if entities[i].Code == "" {
+				return invalid("The code is required.")
+			}
+			entities[i].Name = entities[i].Code
+		}
+		return entities, true
+	case kintone.FT_FILE:
+		var keys []struct {
+			FileKey string `json:"fileKey"`
+		}
+		if err := json.Unmarshal(input, &keys); err != nil {
+			return invalid("The value must be an array of the file keys.")
+		}
+		files := make([]fakeFileValue, 0, len(keys))
+		for i, fileKey := range keys {
+			file, ok := fake.uploads[fileKey.FileKey]
+			if ok {
+				fake.attached = append(fake.attached, fileKey.FileKey)
+			} else {
+				file, ok = fake.files[fileKey.FileKey]
+			}
+			if !ok {
+				errs.add(fmt.Sprintf("%s[%d].fileKey", key, i), fmt.Sprintf("The file (fileKey: %s) does not exist.", fileKey.FileKey))
+				return nil, false
+			}
+			fake.serial++
+			stored := fmt.Sprintf("fake-file-%d", fake.serial)
+			fake.files[stored] = file
+			files = append(files, fakeFileValue{file.ContentType, stored, file.Name, strconv.Itoa(len(file.Data))})
+		}
+		return files, true
+	}
+	return invalid("The field type " + field.Type + " is not supported.")
+}
+
+func fakeString(value interface{}) (string, bool) {
+	switch value := value.(type) {
+	case nil:
+		return "", true
+	case string:
+		return value, true
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+func fakeStrings(value interface{}) ([]string, bool) {
+	if value == nil {
+		return []string{}, true
+	}
+	values, ok := value.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		str, ok := value.(string)
+		if !ok {
+			return nil, false
+		}
+		strs = append(strs, str)
+	}
+	return strs, true
+}
+
+func isEmptyFakeValue(value interface{}) bool {
+	switch value := value.(type) {
+	case nil:
+		return true
+	case string:
+		return value == ""
+	case []string:
+		return len(value) == 0
+	case []fakeEntity:
+		return len(value) == 0
+	case []fakeFileValue:
+		return len(value) == 0
+	case []fakeRow:
+		return len(value) == 0
+	}
+	return false
+}
+
+// renameFakeErrors renames the keys of the errors of records.json to those of record.json
+func renameFakeErrors(err *fakeError, from, to string) *fakeError {
+	if len(err.Errors) == 0 {
+		return err
+	}
+	errors := map[string]interface{}{}
+	for key, messages := range err.Errors {
+		if strings.HasPrefix(key, from) {
+			key = to + strings.TrimPrefix(key, from)
+		}
+		errors[key] = messages
+	}
+	err.Errors = errors
+	return err
+}
+
+func mustMarshal(value interface{}) json.RawMessage {
+	data, err := json.Marshal(value)
+	if err != nil {
+		panic(err)
+	}
+	return data
+}
+
+// fakeParams the parameters of a request, from the JSON body and from the query string
+type fakeParams map[string]json.RawMessage
+
+var fakeArrayParam = regexp.MustCompile(`^(.+)\[\d+\]$`)
+
+func parseFakeParams(r *http.Request) (fakeParams, *fakeError) {
+	params := fakeParams{}
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return nil, newFakeError(http.StatusBadRequest, "CB_IJ01", err.Error())
+	}
+	if len(bytes.TrimSpace(body)) > 0 {
+		if err := json.Unmarshal(body, &params); err != nil {
+			return nil, newFakeError(http.StatusBadRequest, "CB_IJ01", "Invalid JSON string.")
+		}
+	}
+	// fields[0]=a&fields[1]=b
+	arrays := map[string][]string{}
+	keys := make([]string, 0)
+	for key := range r.URL.Query() {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := r.URL.Query().Get(key)
+		if match := fakeArrayParam.FindStringSubmatch(key); match != nil {
+			arrays[match[1]] = append(arrays[match[1]], value)
+			continue
+		}
+		params[key] = mustMarshal(value)
+	}
+	for key, values := range arrays {
+		params[key] = mustMarshal(values)
+	}
+	return params, nil
+}
+
+// String the parameter as a string, the numbers and the booleans are formatted
+func (params fakeParams) String(name string) string {
+	var value interface{}
+	json.Unmarshal(params[name], &value)
+	switch value := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return value
+	}
+	return strings.TrimSpace(string(params[name]))
+}
+
+// Uint the parameter which is a number, or a string of a number
+func (params fakeParams) Uint(name string) uint64 {
+	value, _ := strconv.ParseUint(params.String(name), 10, 64)
+	return value
+}
+
+// Strings the parameter which is an array, the numbers are formatted
+func (params fakeParams) Strings(name string) []string {
+	var values []interface{}
+	json.Unmarshal(params[name], &values)
+	strs := make([]string, 0, len(values))
+	for _, value := range values {
+		str, _ := fakeString(value)
+		strs = append(strs, str)
+	}
+	return strs
+}
+
+func (params fakeParams) Decode(name string, value interface{}) error {
+	if _, ok := params[name]; !ok {
+		return fmt.Errorf("%s is not found", name)
+	}
+	return json.Unmarshal(params[name], value)
+}
+
+// properties the fields in the format of app/form/fields.json
+func (app *fakeApp) properties() map[string]interface{} {
+	property := func(field *kintone.FieldInfo) map[string]interface{} {
+		label := field.Label
+		if label == "" {
+			label = field.Code
+		}
+		properties := map[string]interface{}{
+			"type":     field.Type,
+			"code":     field.Code,
+			"label":    label,
+			"noLabel":  field.NoLabel,
+			"required": field.Required,
+		}
+		switch field.Type {
+		case kintone.FT_SINGLE_LINE_TEXT, kintone.FT_DECIMAL, kintone.FT_LINK:
+			properties["unique"] = field.Unique
+		}
+		if field.MaxLength != nil {
+			properties["maxLength"] = fmt.Sprint(field.MaxLength)
+		}
+		if field.Default != nil {
+			properties["defaultValue"] = field.Default
+		}
+		if len(field.Options) > 0 {
+			options := map[string]interface{}{}
+			for i, option := range field.Options {
+				options[option] = map[string]string{"label": option, "index": strconv.Itoa(i)}
+			}
+			properties["options"] = options
+		}
+		return properties
+	}
+
+	properties := map[string]interface{}{}
+	for _, field := range app.Fields {
+		properties[field.Code] = property(field)
+		if field.Type == kintone.FT_SUBTABLE {
+			fields := map[string]interface{}{}
+			for i := range field.Fields {
+				fields[field.Fields[i].Code] = property(&field.Fields[i])
+			}
+			properties[field.Code].(map[string]interface{})["fields"] = fields
+		}
+	}
+	return properties
+}
+
+// layout the fields in the format of app/form/layout.json, a row for each field
+func (app *fakeApp) layout() []interface{} {
+	layout := make([]interface{}, 0, len(app.Fields))
+	for _, field := range app.Fields {
+		if field.Type == kintone.FT_SUBTABLE {
+			fields := make([]interface{}, 0, len(field.Fields))
+			for _, sub := range field.Fields {
+				fields = append(fields, map[string]string{"type": sub.Type, "code": sub.Code})
+			}
+			layout = append(layout, map[string]interface{}{"type": "SUBTABLE", "code": field.Code, "fields": fields})
+			continue
+		}
+		layout = append(layout, map[string]interface{}{
+			"type":   "ROW",
+			"fields": []interface{}{map[string]string{"type": field.Type, "code": field.Code}},
+		})
+	}
+	return layout
+}
+
+// fakeQuery a query of kintone: the conditions with "and", "or" and the parentheses,
+// "order by", "limit" and "offset"
+type fakeQuery struct {
+	condition func(record fakeRecord) bool
+	orders    []fakeOrder
+	limit     int
+	offset    int
+}
+
+type fakeOrder struct {
+	code string
+	desc bool
+}
+
+var fakeQueryToken = regexp.MustCompile(`\s*("(?:[^"\\]|\\.)*"|!=|<>|>=|<=|[=<>(),]|[^\s=!<>(),"]+)`)
+
+func parseFakeQuery(app *fakeApp, query string) (*fakeQuery, *fakeError) {
+	parser := &fakeQueryParser{app: app}
+	rest := query
+	for strings.TrimSpace(rest) != "" {
+		match := fakeQueryToken.FindStringSubmatchIndex(rest)
+		if match == nil || match[0] != 0 {
+			return nil, parser.invalid(query)
+		}
+		parser.tokens = append(parser.tokens, rest[match[2]:match[3]])
+		rest = rest[match[1]:]
+	}
+	result := &fakeQuery{condition: func(fakeRecord) bool { return true }, limit: -1}
+	if parser.peek() != "" && !parser.keyword("order") && !parser.keyword("limit") && !parser.keyword("offset") {
+		condition, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+		result.condition = condition
+	}
+	if parser.keyword("order") {
+		parser.next()
+		if !parser.keyword("by") {
+			return nil, parser.invalid(query)
+		}
+		parser.next()
+		for {
+			code := parser.next()
+			if err := parser.checkField(code); err != nil {
+				return nil, err
+			}
+			order := fakeOrder{code: code}
+			if parser.keyword("asc") || parser.keyword("desc") {
+				order.desc = strings.EqualFold(parser.next(), "desc")
+			}
+			result.orders = append(result.orders, order)
+			if parser.peek() != "," {
+				break
+			}
+			parser.next()
+		}
+	}
+	for parser.keyword("limit") || parser.keyword("offset") {
+		keyword := strings.ToLower(parser.next())
+		n, err := strconv.Atoi(parser.next())
+		if err != nil || n < 0 {
+			return nil, parser.invalid(query)
+		}
+		if keyword == "limit" {
+			result.limit = n
+		} else {
+			result.offset = n
+		}
+	}
+	if parser.peek() != "" {
+		return nil, parser.invalid(query)
+	}
+	if len(result.orders) == 0 {
+		result.orders = []fakeOrder{{code: "$id", desc: true}}
+	}
+	return result, nil
+}
+
+// run the records of the app which match the conditions, in the order
+func (query *fakeQuery) run(app *fakeApp) []fakeRecord {
+	records := make([]fakeRecord, 0)
+	for _, record := range app.records {
+		if query.condition(record) {
+			records = append(records, record)
+		}
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		for _, order := range query.orders {
+			var value interface{}
+			if field := records[j][order.code]; field != nil {
+				value = field.Value
+			}
+			if c := compareFakeValues(records[i][order.code], value); c != 0 {
+				return (c < 0) != order.desc
+			}
+		}
+		return false
+	})
+	return records
+}
+
+// page the records of the offset and the limit, defaultLimit without limit
+func (query *fakeQuery) page(records []fakeRecord, defaultLimit int) []fakeRecord {
+	limit := query.limit
+	if limit < 0 {
+		limit = defaultLimit
+	}
+	if query.offset >= len(records) {
+		return nil
+	}
+	records = records[query.offset:]
+	if limit < len(records) {
+		records = records[:limit]
+	}
+	return records
+}
+
+var fakeNumericTypes = map[string]bool{kintone.FT_ID: true, kintone.FT_REVISION: true, kintone.FT_DECIMAL: true, kintone.FT_RECNUM: true, kintone.FT_CALC: true}
+
+// compareFakeValues compares the value of the field with a value, as numbers for the numeric fields
+func compareFakeValues(field *fakeField, value interface{}) int {
+	if field == nil {
+		return 0
+	}
+	a, _ := fakeString(field.Value)
+	b, _ := fakeString(value)
+	if fakeNumericTypes[field.Type] {
+		x, errX := strconv.ParseFloat(a, 64)
+		y, errY := strconv.ParseFloat(b, 64)
+		switch {
+		case errX != nil || errY != nil:
+			return strings.Compare(a, b)
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(a, b)
+}
+
+type fakeQueryParser struct {
+	app    *fakeApp
+	tokens []string
+}
+
+func (parser *fakeQueryParser) peek() string {
+	if len(parser.tokens) == 0 {
+		return ""
+	}
+	return parser.tokens[0]
+}
+
+func (parser *fakeQueryParser) next() string {
+	token := parser.peek()
+	if token != "" {
+		parser.tokens = parser.tokens[1:]
+	}
+	return token
+}
+
+func (parser *fakeQueryParser) keyword(keyword string) bool {
+	return strings.EqualFold(parser.peek(), keyword)
+}
+
+func (parser *fakeQueryParser) invalid(query string) *fakeError {
+	return newFakeError(http.StatusBadRequest, "GAIA_IQ03", fmt.Sprintf("The query (%s) is invalid.", query))
+}
+
+func (parser *fakeQueryParser) checkField(code string) *fakeError {
+	if code == "$id" || code == "$revision" || parser.app.field(code) != nil {
+		return nil
+	}
+	return newFakeError(http.StatusBadRequest, "GAIA_IQ11", fmt.Sprintf("The specified field (%s) is not found.", code))
+}
+
+func (parser *fakeQueryParser) or() (func(fakeRecord) bool, *fakeError) {
+	left, err := parser.and()
+	if err != nil {
+		return nil, err
+	}
+	for parser.keyword("or") {
+		parser.next()
+		right, err := parser.and()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record fakeRecord) bool { return l(record) || right(record) }
+	}
+	return left, nil
+}
+
+func (parser *fakeQueryParser) and() (func(fakeRecord) bool, *fakeError) {
+	left, err := parser.condition()
+	if err != nil {
+		return nil, err
+	}
+	for parser.keyword("and") {
+		parser.next()
+		right, err := parser.condition()
+		if err != nil {
+			return nil, err
+		}
+		l := left
+		left = func(record fakeRecord) bool { return l(record) && right(record) }
+	}
+	return left, nil
+}
+
+// value a string or a number
+func (parser *fakeQueryParser) value() (string, *fakeError) {
+	token := parser.next()
+	if strings.HasPrefix(token, `"`) {
+		var value string
+		if err := json.Unmarshal([]byte(token), &value); err != nil {
+			// the escapes of kintone are only \" and \\
+			value = strings.NewReplacer(`\"`, `"`, `\\`, `\`).Replace(token[1 : len(token)-1])
+		}
+		return value, nil
+	}
+	if _, err := strconv.ParseFloat(token, 64); err != nil {
+		return "", parser.invalid(token)
+	}
+	return token, nil
+}
+
+func (parser *fakeQueryParser) condition() (func(fakeRecord) bool, *fakeError) {
+	if parser.peek() == "(" {
+		parser.next()
+		condition, err := parser.or()
+		if err != nil {
+			return nil, err
+		}
+		if parser.next() != ")" {
+			return nil, parser.invalid("(")
+		}
+		return condition, nil
+	}
+
+	code := parser.next()
+	if err := parser.checkField(code); err != nil {
+		return nil, err
+	}
+	not := false
+	if parser.keyword("not") {
+		parser.next()
+		not = true
+	}
+	operator := strings.ToLower(parser.next())
+	switch operator {
+	case "in":
+		if parser.next() != "(" {
+			return nil, parser.invalid("in")
+		}
+		values := []string{}
+		for {
+			value, err := parser.value()
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+			if token := parser.next(); token == ")" {
+				break
+			} else if token != "," {
+				return nil, parser.invalid("in")
+			}
+		}
+		return func(record fakeRecord) bool {
+			return fakeContains(record[code], values) != not
+		}, nil
+	case "like":
+		value, err := parser.value()
+		if err != nil {
+			return nil, err
+		}
+		return func(record fakeRecord) bool {
+			text, _ := fakeString(record[code].Value)
+			return strings.Contains(strings.ToLower(text), strings.ToLower(value)) != not
+		}, nil
+	}
+	if not {
+		return nil, parser.invalid("not")
+	}
+	value, err := parser.value()
+	if err != nil {
+		return nil, err
+	}
+	compare := map[string]func(int) bool{
+		"=":  func(c int) bool { return c == 0 },
+		"!=": func(c int) bool { return c != 0 },
+		"<>": func(c int) bool { return c != 0 },
+		">":  func(c int) bool { return c > 0 },
+		"<":  func(c int) bool { return c < 0 },
+		">=": func(c int) bool { return c >= 0 },
+		"<=": func(c int) bool { return c <= 0 },
+	}[operator]
+	if compare == nil {
+		return nil, parser.invalid(operator)
+	}
+	return func(record fakeRecord) bool {
+		return compare(compareFakeValues(record[code], value))
+	}, nil
+}
+
+// fakeContains reports whether the value of the field, or one of its values, is in the values
+func fakeContains(field *fakeField, values []string) bool {
+	var fieldValues []string
+	switch value := field.Value.(type) {
+	case []string:
+		fieldValues = value
+	case []fakeEntity:
+		for _, entity := range value {
+			fieldValues = append(fieldValues, entity.Code)
+		}
+	default:
+		text, _ := fakeString(value)
+		fieldValues = []string{text}
+	}
+	for _, fieldValue := range fieldValues {
+		for _, value := range values {
+			if fieldValue == value {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func TestFakeKintone(t *testing.T) {
+	fake := newFakeKintone(t, &fakeApp{
+		ID:           2,
+		GuestSpaceID: 3,
+		APITokens:    []string{"token"},
+		Fields: []*kintone.FieldInfo{
+			{Code: "key", Type: kintone.FT_SINGLE_LINE_TEXT, Required: true, Unique: true},
+			{Code: "choice", Type: kintone.FT_CHECK_BOX, Options: []string{"a", "b"}},
+			{Code: "file", Type: kintone.FT_FILE},
+		},
+	}, &fakeApp{ID: 4, APITokens: []string{"other"}})
+	app := fake.App(2)
+
+	fileKey, err := app.Upload("a.txt", "text/plain", strings.NewReader("attachment"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := make([]*kintone.Record, 0)
+	for i := 1; i <= 5; i++ {
+		fields := map[string]interface{}{
+			"key":    kintone.SingleLineTextField(fmt.Sprintf("key%d", i)),
+			"choice": kintone.CheckBoxField([]string{"a"}),
+		}
+		if i == 1 {
+			fields["file"] = kintone.FileField{{FileKey: fileKey}}
+		}
+		records = append(records, kintone.NewRecord(fields))
+	}
+	if _, err := app.AddRecords(records); err != nil {
+		t.Fatal(err)
+	}
+
+	// the query
+	recs, err := app.GetRecords([]string{"$id", "key"}, `(key = "key2" or key like "5") and choice in ("a") order by $id asc limit 1 offset 1`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 || recs[0].Id() != 5 || len(recs[0].Fields) != 1 {
+		t.Errorf("the record 5 must be found with its key only: %v", recs)
+	}
+
+	// the cursor
+	cursor, err := app.CreateCursor(nil, "$id > 1", 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, _ := app.GetRecordsByCursor(cursor.Id)
+	second, _ := app.GetRecordsByCursor(cursor.Id)
+	if cursor.TotalCount != "4" || len(first.Records) != 3 || !first.Next || len(second.Records) != 1 || second.Next {
+		t.Errorf("the cursor must return 3 records and 1 record: %s %v %v", cursor.TotalCount, first, second)
+	}
+	if err := app.DeleteCursor(cursor.Id); err == nil {
+		t.Error("the cursor must be deleted after its last records")
+	}
+
+	// the file is stored with another key
+	recs, _ = app.GetRecords([]string{"file"}, "$id = 1")
+	files := recs[0].Fields["file"].(kintone.FileField)
+	if len(files) != 1 || files[0].FileKey == fileKey || files[0].Name != "a.txt" {
+		t.Fatalf("the file must be stored: %v", files)
+	}
+	data, err := app.Download(files[0].FileKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, _ := ioutil.ReadAll(data.Reader)
+	if string(content) != "attachment" {
+		t.Errorf("the file must be downloaded but %q", content)
+	}
+
+	// the errors
+	tests := []struct {
+		app    *kintone.App
+		record map[string]interface{}
+		status int
+		code   string
+		key    string
+	}{
+		{app, map[string]interface{}{"key": kintone.SingleLineTextField("key1")}, 400, "CB_VA01", "records[0].key.value"},
+		{app, map[string]interface{}{"choice": kintone.CheckBoxField([]string{"c"})}, 400, "CB_VA01", "records[0].choice.value"},
+		{&kintone.App{Domain: app.Domain, AppId: 2, GuestSpaceId: 3, ApiToken: "invalid", Client: app.Client}, nil, 401, "GAIA_IA02", ""},
+		{&kintone.App{Domain: app.Domain, AppId: 2, GuestSpaceId: 3, ApiToken: "other", Client: app.Client}, nil, 403, "GAIA_NO01", ""},
+		{&kintone.App{Domain: app.Domain, AppId: 2, ApiToken: "token", Client: app.Client}, nil, 400, "GAIA_IL23", ""},
+		{&kintone.App{Domain: app.Domain, AppId: 2, GuestSpaceId: 3, User: "user", Password: "wrong", Client: app.Client}, nil, 401, "CB_WA01", ""},
+	}
+	for _, test := range tests {
+		record := test.record
+		if record == nil {
+			record = map[string]interface{}{"key": kintone.SingleLineTextField("new")}
+		}
+		_, err := test.app.AddRecords([]*kintone.Record{kintone.NewRecord(record)})
+		appErr, ok := err.(*kintone.AppError)
+		if !ok || appErr.HttpStatusCode != test.status || appErr.Code != test.code {
+			t.Errorf("the error must be %d %s but %v", test.status, test.code, err)
+			continue
+		}
+		if errors, _ := appErr.Errors.(map[string]interface{}); test.key != "" && errors[test.key] == nil {
+			t.Errorf("the error must be of %s but %v", test.key, appErr.Errors)
+		}
+	}
+	if _, err := app.GetRecords(nil, "unknown = 1"); err == nil || err.(*kintone.AppError).Code != "GAIA_IQ11" {
+		t.Errorf("the unknown field of the query must be an error: %v", err)
+	}
+	if len(fake.Records(2)) != 5 {
+		t.Errorf("the invalid records must not be added")
+	}
+}
@@ -6,7 +6,7 @@ require (
 	github.com/howeyc/gopass v0.0.0-20170109162249-bf9dde6d0d2c
 	github.com/jessevdk/go-flags v1.5.0
 	github.com/kintone-labs/go-kintone v0.4.3
-	golang.org/x/crypto v0.0.0-20180501155221-613d6eafa307 // indirect
+	golang.org/x/crypto v0.0.0-20180501155221-613d6eafa307
 	golang.org/x/sys v0.0.0-20210514084401-e8d321eab015 // indirect
 	golang.org/x/text v0.3.1-0.20180410181320-7922cc490dd5
 )
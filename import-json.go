@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// jsonField is a field value in the format written by the JSON export
+type jsonField struct {
+	Type  string          `json:"type"`
+	Value json.RawMessage `json:"value"`
+}
+
+// jsonRecord is a record in the format written by the JSON export
+type jsonRecord map[string]*jsonField
+
+// jsonSubTableRow is a row of a subtable in the format written by the JSON export
+type jsonSubTableRow struct {
+	ID    string     `json:"id"`
+	Value jsonRecord `json:"value"`
+}
+
+// detectJSONFormat returns 'json' when the input is a {"records": [...]} document,
+// 'jsonl' when it is one record per line and "" when it is not JSON, with a reader of the whole input.
+// The keys of the first JSON object are read until "records" is found.
+func detectJSONFormat(reader io.Reader) (string, io.Reader) {
+	bufferReader := bufio.NewReader(reader)
+	if _, err := bufferReader.Peek(1); err != nil {
+		return "", bufferReader
+	}
+	head := &bytes.Buffer{}
+	decoder := json.NewDecoder(getReader(io.TeeReader(bufferReader, head)))
+	return detectJSONValue(decoder), io.MultiReader(head, bufferReader)
+}
+
+func detectJSONValue(decoder *json.Decoder) string {
+	if err := readJSONDelim(decoder, '{'); err != nil {
+		return ""
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			// an invalid record is reported by the JSON Lines import
+			return "jsonl"
+		}
+		if token == "records" {
+			if token, err = decoder.Token(); err == nil && token == json.Delim('[') {
+				return "json"
+			}
+			return "jsonl"
+		}
+		var skipped json.RawMessage
+		if err := decoder.Decode(&skipped); err != nil {
+			return "jsonl"
+		}
+	}
+	return "jsonl"
+}
+
+func readJSONDelim(decoder *json.Decoder, delim json.Delim) error {
+	token, err := decoder.Token()
+	if err != nil {
+		return err
+	}
+	if token != delim {
+		return fmt.Errorf("Invalid JSON format: expected '%v' but found '%v'", delim, token)
+	}
+	return nil
+}
+
+// importFromJSON import the {"records": [...]} document written by the JSON export
+func importFromJSON(app *kintone.App, reader io.Reader, checkpoint *ImportCheckpoint) error {
+	readRecord, err := readJSONDocument(reader)
+	if err != nil {
+		return err
+	}
+	// the records committed before the checkpoint are skipped by their position
+	return importJSONRecords(app, checkpoint, 1, readRecord)
+}
+
+// readJSONDocument returns the function reading the records of the {"records": [...]} document one by one
+func readJSONDocument(reader io.Reader) (func() (json.RawMessage, uint64, int64, error), error) {
+	decoder := json.NewDecoder(getReader(reader))
+
+	if err := readJSONDelim(decoder, '{'); err != nil {
+		return nil, err
+	}
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, err
+		}
+		key, ok := token.(string)
+		if !ok {
+			return nil, fmt.Errorf("Invalid JSON format: \"records\" was not found")
+		}
+		if key == "records" {
+			break
+		}
+		var skipped json.RawMessage
+		if err := decoder.Decode(&skipped); err != nil {
+			return nil, err
+		}
+	}
+	if err := readJSONDelim(decoder, '['); err != nil {
+		return nil, err
+	}
+
+	return func() (json.RawMessage, uint64, int64, error) {
+		if !decoder.More() {
+			return nil, 0, 0, io.EOF
+		}
+		var data json.RawMessage
+		err := decoder.Decode(&data)
+		return data, 0, decoder.InputOffset(), err
+	}, nil
+}
+
+// importFromJSONL import the records written one per line by the 'jsonl' export
+func importFromJSONL(app *kintone.App, _reader io.Reader, checkpoint *ImportCheckpoint) error {
+	input := &countReader{reader: getReader(_reader)}
+	reader := bufio.NewReader(input)
+
+	var lineNumber uint64
+	committedRow, committedLine, committedOffset := checkpoint.Start()
+	if committedRow > 0 {
+		// skip the records committed before the checkpoint
+		if _, err := io.CopyN(ioutil.Discard, reader, committedOffset); err != nil {
+			return err
+		}
+		lineNumber = committedLine
+	}
+	return importJSONRecords(app, checkpoint, committedRow+1, readJSONLines(input, reader, lineNumber))
+}
+
+// readJSONLines returns the function reading the records one per line after the line lineNumber
+func readJSONLines(input *countReader, reader *bufio.Reader, lineNumber uint64) func() (json.RawMessage, uint64, int64, error) {
+	return func() (json.RawMessage, uint64, int64, error) {
+		for {
+			line, err := reader.ReadBytes('\n')
+			if err != nil && (err != io.EOF || len(line) == 0) {
+				return nil, 0, 0, err
+			}
+			lineNumber++
+			if len(strings.TrimSpace(string(line))) == 0 {
+				continue
+			}
+			return json.RawMessage(line), lineNumber, input.count - int64(reader.Buffered()), nil
+		}
+	}
+}
+
+// importJSONRecords import the records returned by readRecord until it returns io.EOF.
+// readRecord also returns the line number of the record, or 0 if it is unknown, and the bytes of the input
+// read up to the end of the record. firstRow is the position of the first record returned by readRecord.
+func importJSONRecords(app *kintone.App, checkpoint *ImportCheckpoint, firstRow uint64, readRecord func() (json.RawMessage, uint64, int64, error)) error {
+	var nextRowImport uint64
+	nextRowImport = config.Line
+	committedRow, _, _ := checkpoint.Start()
+	if committedRow+1 > nextRowImport {
+		nextRowImport = committedRow + 1
+	}
+	bulkRequests := &BulkRequests{}
+	result := &importResult{}
+	// retrieve field list
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+	rejects, err := newRejectWriter(config.RejectFile)
+	if err != nil {
+		return err
+	}
+	defer rejects.Close()
+	columns := make(Columns, 0, len(fields))
+	for _, field := range fields {
+		columns = append(columns, &Column{Code: field.Code, Type: field.Type})
+	}
+
+	if config.DeleteAll {
+		err = deleteRecordsBeforeImport(app, result)
+		if err != nil {
+			return err
+		}
+		config.DeleteAll = false
+	}
+	// the uploads are stopped after the bulkRequests waiting for them
+	defer closeUploader()
+	pipeline := newImportPipeline(app, result, rejects, checkpoint)
+	defer pipeline.Close()
+
+	// the end of the last record, saved to the checkpoint
+	var recordLine uint64
+	var recordOffset int64
+	var rowNumber uint64
+	for rowNumber = firstRow; ; rowNumber++ {
+		raw, line, offset, err := readRecord()
+		if err == io.EOF {
+			rowNumber--
+			break
+		} else if err != nil {
+			return err
+		}
+		if rowNumber < config.Line || rowNumber <= committedRow || checkpoint.IsCommitted(rowNumber) {
+			continue
+		}
+		recordLine, recordOffset = line, offset
+
+		var data jsonRecord
+		err = json.Unmarshal(raw, &data)
+		if err != nil {
+			return fmt.Errorf("\nrecord[%d]: %v", rowNumber, err)
+		}
+		id, record, err := makeRecordFromJSON(app, data, fields)
+		if err != nil {
+			return fmt.Errorf("\nrecord[%d]: %v", rowNumber, err)
+		}
+
+		source := &BulkRequestSource{Row: rowNumber, Data: raw, files: endRecordUploads()}
+		if line > 0 {
+			source.Lines = []uint64{line}
+		}
+		if id != 0 {
+			setRecordUpdatable(record, columns)
+			recordData := kintone.NewRecordWithId(id, record)
+			bulkRequests.SetSource(recordData, source)
+			err = bulkRequests.ImportDataUpdate(app, recordData, "")
+			if err != nil {
+				return err
+			}
+		} else {
+			recordData := kintone.NewRecord(record)
+			bulkRequests.SetSource(recordData, source)
+			err = bulkRequests.ImportDataInsert(app, recordData)
+			if err != nil {
+				return err
+			}
+		}
+		if pipeline.IsFull(bulkRequests) {
+			err = pipeline.Send(bulkRequests, nextRowImport, rowNumber, recordLine, recordOffset)
+			if err != nil {
+				return err
+			}
+
+			bulkRequests = &BulkRequests{}
+			nextRowImport = rowNumber + 1
+		}
+	}
+	err = pipeline.Send(bulkRequests, nextRowImport, rowNumber, recordLine, recordOffset)
+	if err != nil {
+		return err
+	}
+	if err := pipeline.Close(); err != nil {
+		return err
+	}
+	if err := checkpoint.Done(); err != nil {
+		return err
+	}
+
+	return result.show()
+}
+
+// makeRecordFromJSON convert a record of the JSON export into the fields of a record.
+// The values are converted with the same rules as the CSV import.
+func makeRecordFromJSON(app *kintone.App, data jsonRecord, fields map[string]*kintone.FieldInfo) (uint64, map[string]interface{}, error) {
+	var id uint64
+	record := make(map[string]interface{})
+
+	for code, field := range data {
+		if field == nil {
+			continue
+		}
+		value, err := jsonFieldString(field.Value)
+		if err != nil {
+			return 0, nil, fmt.Errorf("field[%s]: %v", code, err)
+		}
+
+		if code == "$id" {
+			if value != "" {
+				id, err = strconv.ParseUint(value, 10, 64)
+				if err != nil {
+					return 0, nil, fmt.Errorf("field[%s]: %v", code, err)
+				}
+			}
+			continue
+		} else if code == "$revision" {
+			continue
+		}
+
+		column := getColumn(code, fields)
+		if column.IsSubField {
+			continue
+		}
+		if column.Type == kintone.FT_SUBTABLE {
+			table, err := makeSubTableFromJSON(app, field.Value, fields)
+			if err != nil {
+				return 0, nil, fmt.Errorf("field[%s]: %v", code, err)
+			}
+			record[code] = table
+		} else if column.Type == kintone.FT_FILE {
+			files, err := uploadFiles(app, value)
+			if err != nil {
+				return 0, nil, fmt.Errorf("field[%s]: %v", code, err)
+			}
+			if files != nil {
+				record[code] = files
+			}
+		} else {
+			f := getField(column.Type, value)
+			if f != nil {
+				record[code] = f
+			}
+		}
+	}
+	return id, record, nil
+}
+
+func makeSubTableFromJSON(app *kintone.App, value json.RawMessage, fields map[string]*kintone.FieldInfo) (kintone.SubTableField, error) {
+	stf := getField(kintone.FT_SUBTABLE, "").(kintone.SubTableField)
+	if len(value) == 0 {
+		return stf, nil
+	}
+
+	var rows []*jsonSubTableRow
+	if err := json.Unmarshal(value, &rows); err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		if row == nil {
+			continue
+		}
+		table := &SubRecord{0, make(map[string]interface{})}
+		if row.ID != "" {
+			subID, err := strconv.ParseUint(row.ID, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			table.Id = subID
+		}
+		for code, field := range row.Value {
+			if field == nil {
+				continue
+			}
+			column := getColumn(code, fields)
+			if !column.IsSubField {
+				continue
+			}
+			col, err := jsonFieldString(field.Value)
+			if err != nil {
+				return nil, err
+			}
+			if err := addSubField(app, column, col, table); err != nil {
+				return nil, err
+			}
+		}
+		stf = append(stf, kintone.NewRecordWithId(table.Id, table.Fields))
+	}
+	return stf, nil
+}
+
+// jsonFieldString convert the value of a field into the string used by the CSV format
+func jsonFieldString(value json.RawMessage) (string, error) {
+	if len(value) == 0 {
+		return "", nil
+	}
+	var v interface{}
+	if err := json.Unmarshal(value, &v); err != nil {
+		return "", err
+	}
+	return jsonValueToString(v), nil
+}
+
+func jsonValueToString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case map[string]interface{}:
+		// user, organization, group or file
+		if code, ok := v["code"]; ok {
+			return jsonValueToString(code)
+		}
+		if name, ok := v["name"]; ok {
+			return jsonValueToString(name)
+		}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			values = append(values, jsonValueToString(item))
+		}
+		return strings.Join(values, "\n")
+	}
+	return ""
+}
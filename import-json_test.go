@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+func TestDetectJSONFormat(t *testing.T) {
+	tests := map[string]string{
+		"{\"records\": []}":                                   "json",
+		"\uFEFF  \n{\"records\": []}":                         "json",
+		"{\"app\": {\"id\": [1, {}]}, \"records\": [{}]}":     "json",
+		"{\"$id\":{\"value\":\"1\"}}\n{\"$id\":{}}\n":         "jsonl",
+		"{\"records\":{\"type\":\"SINGLE_LINE_TEXT\"}}\n{}\n": "jsonl",
+		"\"$id\",\"Text\"\n1,aaa":                             "",
+		"Text,Text_Area":                                      "",
+		"":                                                    "",
+	}
+	for input, expected := range tests {
+		format, reader := detectJSONFormat(strings.NewReader(input))
+		if format != expected {
+			t.Errorf("detectJSONFormat(%q) must be %q but %q", input, expected, format)
+		}
+		if data, _ := ioutil.ReadAll(reader); string(data) != input {
+			t.Errorf("detectJSONFormat(%q) must return the whole input but %q", input, data)
+		}
+	}
+}
+
+func TestMakeRecordFromJSON(t *testing.T) {
+	fields := map[string]*kintone.FieldInfo{
+		"Text":      {Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT},
+		"Check_box": {Code: "Check_box", Type: kintone.FT_CHECK_BOX},
+		"User":      {Code: "User", Type: kintone.FT_USER},
+		"Table": {Code: "Table", Type: kintone.FT_SUBTABLE, Fields: []kintone.FieldInfo{
+			{Code: "Table_text", Type: kintone.FT_SINGLE_LINE_TEXT},
+		}},
+	}
+	data := `{
+		"$id": {"type": "__ID__", "value": "12"},
+		"$revision": {"type": "__REVISION__", "value": "3"},
+		"Text": {"type": "SINGLE_LINE_TEXT", "value": "aaa"},
+		"Check_box": {"type": "CHECK_BOX", "value": ["a", "b"]},
+		"User": {"type": "USER_SELECT", "value": [{"code": "user1", "name": "User 1"}]},
+		"Table": {"type": "SUBTABLE", "value": [
+			{"id": "34", "value": {"Table_text": {"type": "SINGLE_LINE_TEXT", "value": "bbb"}}}
+		]},
+		"Unknown": {"type": "SINGLE_LINE_TEXT", "value": "ccc"}
+	}`
+	var record jsonRecord
+	if err := json.Unmarshal([]byte(data), &record); err != nil {
+		t.Fatal(err)
+	}
+
+	config.FileDir = ""
+	id, result, err := makeRecordFromJSON(nil, record, fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != 12 {
+		t.Error("$id mismatch")
+	}
+	if result["Text"] != kintone.SingleLineTextField("aaa") {
+		t.Error("Text mismatch")
+	}
+	if checkBox, ok := result["Check_box"].(kintone.CheckBoxField); !ok || len(checkBox) != 2 || checkBox[1] != "b" {
+		t.Error("Check_box mismatch")
+	}
+	if user, ok := result["User"].(kintone.UserField); !ok || len(user) != 1 || user[0].Code != "user1" {
+		t.Error("User mismatch")
+	}
+	table, ok := result["Table"].(kintone.SubTableField)
+	if !ok || len(table) != 1 {
+		t.Fatal("Table mismatch")
+	}
+	if table[0].Id() != 34 || table[0].Fields["Table_text"] != kintone.SingleLineTextField("bbb") {
+		t.Error("Table row mismatch")
+	}
+	if _, ok := result["Unknown"]; ok {
+		t.Error("Unknown field must be skipped")
+	}
+}
+
+func TestMarshalRecordJSON(t *testing.T) {
+	record := kintone.NewRecordWithId(5, map[string]interface{}{
+		"Text": kintone.SingleLineTextField("aaa"),
+	})
+	data, err := marshalRecordJSON(record, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var parsed jsonRecord
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		t.Fatal(err)
+	}
+	if parsed["$id"] == nil {
+		t.Fatal("$id must be exported")
+	}
+	if value, _ := jsonFieldString(parsed["$id"].Value); value != "5" {
+		t.Error("$id mismatch")
+	}
+
+	data, err = marshalRecordJSON(record, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "$id") {
+		t.Error("$id must not be exported")
+	}
+}
@@ -1,11 +1,12 @@
 package main
 
 import (
+	"bufio"
 	"encoding/csv"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"log"
-	"os"
 	"path"
 	"path/filepath"
 	"regexp"
@@ -77,6 +78,105 @@ func deleteRecords(app *kintone.App, query string) error {
 
 	return nil
 }
+
+// importResult counts the records processed by the import
+type importResult struct {
+	Deleted  uint64
+	Inserted uint64
+	Updated  uint64
+	Rejected uint64
+}
+
+// show the result, and return an error if some records were rejected
+func (result *importResult) show() error {
+	errSkipped := showSkippedFiles()
+	showTimeLog()
+	if config.DryRun {
+		fmt.Printf("DRY RUN DONE: %d records will be deleted, %d records will be inserted, %d records will be updated\n", result.Deleted, result.Inserted, result.Updated)
+		return nil
+	}
+	if result.Rejected == 0 {
+		fmt.Printf("DONE\n")
+		return errSkipped
+	}
+	fmt.Printf("DONE: %d records were inserted, %d records were updated, %d records were rejected\n", result.Inserted, result.Updated, result.Rejected)
+	if config.RejectFile != "" {
+		return fmt.Errorf("%d records were rejected. Please fix the errors in \"%s\" and import it again", result.Rejected, config.RejectFile)
+	}
+	return fmt.Errorf("%d records were rejected", result.Rejected)
+}
+
+// count the records which deleteRecords will delete
+func countRecords(app *kintone.App, query string) (uint64, error) {
+	r := regexp.MustCompile(`limit\s+\d+`)
+	if r.MatchString(query) {
+		records, err := app.GetRecords([]string{"$id"}, query)
+		if err != nil {
+			return 0, err
+		}
+		return uint64(len(records)), nil
+	}
+
+	cursor, err := app.CreateCursor([]string{"$id"}, query, 1)
+	if err != nil {
+		return 0, err
+	}
+	app.DeleteCursor(cursor.Id)
+	return strconv.ParseUint(cursor.TotalCount, 10, 64)
+}
+
+// delete the records before the import (-D), only count them in dry-run mode
+func deleteRecordsBeforeImport(app *kintone.App, result *importResult) error {
+	if !config.DryRun {
+		return deleteRecords(app, config.Query)
+	}
+	count, err := countRecords(app, config.Query)
+	if err != nil {
+		return err
+	}
+	result.Deleted = count
+	showTimeLog()
+	fmt.Printf("DRY RUN: %d records will be deleted\n", count)
+	return nil
+}
+
+// send the records of the bulkRequest one by one and write the rejected records to the reject file
+func sendBulkRequestsEach(app *kintone.App, bulkRequests *BulkRequests, result *importResult, rejects *RejectWriter) error {
+	// the requests processed by kintone are not sent again
+	committedInserted, committedUpdated := countRequestRecords(bulkRequests.Committed)
+	result.Inserted += uint64(committedInserted)
+	result.Updated += uint64(committedUpdated)
+	for _, single := range bulkRequests.Split() {
+		inserted, updated := single.CountRecords()
+		var err interface{}
+		if errUpload := single.getUploadError(); errUpload != nil {
+			// the record whose files were not uploaded is not sent
+			err = errUpload
+		} else {
+			_, err = single.Request(app)
+		}
+		if err == nil {
+			result.Inserted += uint64(inserted)
+			result.Updated += uint64(updated)
+			continue
+		}
+		if !single.canResend(err) {
+			return fmt.Errorf("The import was stopped because it is unknown if the record was added: %v", err)
+		}
+
+		result.Rejected++
+		code, message := single.getErrorDetail(err)
+		for _, source := range single.Sources {
+			showTimeLog()
+			fmt.Printf("%s => REJECTED [%s] %s\n", source.Position(), code, message)
+			if err := rejects.Write(source, code, message); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
 func getSubRecord(tableName string, tables map[string]*SubRecord) *SubRecord {
 	table := tables[tableName]
 	if table == nil {
@@ -108,39 +208,78 @@ func addSubField(app *kintone.App, column *Column, col string, table *SubRecord)
 	}
 	return nil
 }
-func importFromCSV(app *kintone.App, _reader io.Reader) error {
+func importFromCSV(app *kintone.App, _reader io.Reader, checkpoint *ImportCheckpoint) error {
 
-	reader := csv.NewReader(getReader(_reader))
+	// the CSV reader reads from buffered directly, so the offset of each row is known
+	input := &countReader{reader: getReader(_reader)}
+	buffered := bufio.NewReader(input)
+	reader := csv.NewReader(buffered)
 
 	head := true
 	var columns Columns
+	var columnIndexes map[string]int
+
+	// line number where the next row starts
+	var nextLine uint64 = 1
+	// bytes of the input read up to the end of the last row
+	readOffset := func() int64 {
+		return input.count - int64(buffered.Buffered())
+	}
+	readRow := func() ([]string, uint64, error) {
+		row, err := reader.Read()
+		if err != nil {
+			return nil, 0, err
+		}
+		line := nextLine
+		nextLine += 1 + countLineBreaks(row)
+		return row, line, nil
+	}
 
 	var nextRowImport uint64
 	nextRowImport = config.Line
+	committedRow, committedLine, committedOffset := checkpoint.Start()
+	if committedRow+1 > nextRowImport {
+		nextRowImport = committedRow + 1
+	}
 	bulkRequests := &BulkRequests{}
+	result := &importResult{}
 	// retrieve field list
 	fields, err := getFields(app)
 	if err != nil {
 		return err
 	}
+	rejects, err := newRejectWriter(config.RejectFile)
+	if err != nil {
+		return err
+	}
+	defer rejects.Close()
 
 	if config.DeleteAll {
-		err = deleteRecords(app, config.Query)
+		err = deleteRecordsBeforeImport(app, result)
 		if err != nil {
 			return err
 		}
 		config.DeleteAll = false
 	}
+	// the uploads are stopped after the bulkRequests waiting for them
+	defer closeUploader()
+	pipeline := newImportPipeline(app, result, rejects, checkpoint)
+	defer pipeline.Close()
 
 	keyField := ""
 	hasTable := false
 	var peeked *[]string
+	var peekedLine uint64
+	// the end of the last record, saved to the checkpoint
+	var recordLine uint64
+	var recordOffset int64
 	var rowNumber uint64
 	for rowNumber = 1; ; rowNumber++ {
 		var err error
 		var row []string
+		var rowLine uint64
 		if peeked == nil {
-			row, err = reader.Read()
+			row, rowLine, err = readRow()
 			if err == io.EOF {
 				rowNumber--
 				break
@@ -149,17 +288,20 @@ func importFromCSV(app *kintone.App, _reader io.Reader) error {
 			}
 		} else {
 			row = *peeked
+			rowLine = peekedLine
 			peeked = nil
 		}
 		if head && columns == nil {
 			columns = make([]*Column, 0)
-			for _, col := range row {
+			columnIndexes = make(map[string]int)
+			for i, col := range row {
 				re := regexp.MustCompile("^(.*)\\[(.*)\\]$")
 				match := re.FindStringSubmatch(col)
 				if match != nil {
 					// for backward compatible
 					column := &Column{Code: match[1], Type: match[2]}
 					columns = append(columns, column)
+					columnIndexes[column.Code] = i
 					col = column.Code
 				} else {
 					if len(col) > 0 && col[0] == '*' {
@@ -173,9 +315,19 @@ func importFromCSV(app *kintone.App, _reader io.Reader) error {
 						}
 					}
 					columns = append(columns, column)
+					columnIndexes[column.Code] = i
 				}
 			}
+			rejects.SetHeader(row)
 			head = false
+			if committedRow > 0 {
+				// skip the records committed before the checkpoint
+				if _, err := io.CopyN(ioutil.Discard, buffered, committedOffset-readOffset()); err != nil {
+					return err
+				}
+				nextLine = committedLine + 1
+				rowNumber = committedRow
+			}
 		} else {
 			if rowNumber < config.Line {
 				continue
@@ -184,8 +336,14 @@ func importFromCSV(app *kintone.App, _reader io.Reader) error {
 			var err error
 			record := make(map[string]interface{})
 			hasId := false
+			source := &BulkRequestSource{Row: rowNumber, Columns: columnIndexes, SubRows: make(map[string][]int)}
+			// committed by the interrupted import
+			skip := checkpoint.IsCommitted(rowNumber)
 
 			for {
+				source.Rows = append(source.Rows, row)
+				source.Lines = append(source.Lines, rowLine)
+				recordOffset = readOffset()
 				tables := make(map[string]*SubRecord)
 				for i, col := range row {
 					column := columns[i]
@@ -213,10 +371,12 @@ func importFromCSV(app *kintone.App, _reader io.Reader) error {
 						} else if column.Code == "$revision" {
 
 						} else if column.Type == kintone.FT_FILE {
-
+							if skip {
+								continue
+							}
 							field, err := uploadFiles(app, col)
 							if err != nil {
-								return fmt.Errorf("\ncolumn[" + strconv.Itoa(i) +"]"+ " - row[" + strconv.FormatUint(rowNumber, 10)+"]: "+ err.Error())
+								return fmt.Errorf("\ncolumn[" + strconv.Itoa(i) + "]" + " - row[" + strconv.FormatUint(rowNumber, 10) + "]: " + err.Error())
 							}
 							if field != nil {
 								record[column.Code] = field
@@ -243,12 +403,13 @@ func importFromCSV(app *kintone.App, _reader io.Reader) error {
 					stf := record[key].(kintone.SubTableField)
 					stf = append(stf, kintone.NewRecordWithId(table.Id, table.Fields))
 					record[key] = stf
+					source.SubRows[key] = append(source.SubRows[key], len(source.Rows)-1)
 				}
 
 				if !hasTable {
 					break
 				}
-				row, err = reader.Read()
+				row, rowLine, err = readRow()
 				if err == io.EOF {
 					break
 				} else if err != nil {
@@ -256,50 +417,61 @@ func importFromCSV(app *kintone.App, _reader io.Reader) error {
 				}
 				if len(row) > 0 && row[0] == "*" {
 					peeked = &row
+					peekedLine = rowLine
 					break
 				}
 			}
+			source.files = endRecordUploads()
+			recordLine = source.LastLine()
+			if skip {
+				continue
+			}
 
 			if hasId && keyField != "" {
-				log.Fatalln("The \"$id\" field and update key fields cannot be specified together in CSV import file.");
+				log.Fatalln("The \"$id\" field and update key fields cannot be specified together in CSV import file.")
 			}
 
 			_, hasKeyField := record[keyField]
 			if id != 0 || (keyField != "" && hasKeyField) {
 				setRecordUpdatable(record, columns)
-				err = bulkRequests.ImportDataUpdate(app, kintone.NewRecordWithId(id, record), keyField)
+				recordData := kintone.NewRecordWithId(id, record)
+				bulkRequests.SetSource(recordData, source)
+				err = bulkRequests.ImportDataUpdate(app, recordData, keyField)
 				if err != nil {
 					log.Fatalln(err)
 				}
 			} else {
-				err = bulkRequests.ImportDataInsert(app, kintone.NewRecord(record))
+				recordData := kintone.NewRecord(record)
+				bulkRequests.SetSource(recordData, source)
+				err = bulkRequests.ImportDataInsert(app, recordData)
 				if err != nil {
 					log.Fatalln(err)
 				}
 			}
-			if (rowNumber-nextRowImport+1)%(ConstBulkRequestLimitRecordOption) == 0 {
-				showTimeLog()
-				fmt.Printf("Start from lines: %d - %d", nextRowImport, rowNumber)
-
-				resp, err := bulkRequests.Request(app)
-				bulkRequests.HandelResponse(resp, err, nextRowImport, rowNumber)
+			if pipeline.IsFull(bulkRequests) {
+				err = pipeline.Send(bulkRequests, nextRowImport, rowNumber, recordLine, recordOffset)
+				if err != nil {
+					return err
+				}
 
-				bulkRequests.Requests = bulkRequests.Requests[:0]
+				bulkRequests = &BulkRequests{}
 				nextRowImport = rowNumber + 1
 
 			}
 		}
 	}
-	if len(bulkRequests.Requests) > 0 {
-		showTimeLog()
-		fmt.Printf("Start from lines: %d - %d", nextRowImport, rowNumber)
-		resp, err := bulkRequests.Request(app)
-		bulkRequests.HandelResponse(resp, err, nextRowImport, rowNumber)
+	err = pipeline.Send(bulkRequests, nextRowImport, rowNumber, recordLine, recordOffset)
+	if err != nil {
+		return err
+	}
+	if err := pipeline.Close(); err != nil {
+		return err
+	}
+	if err := checkpoint.Done(); err != nil {
+		return err
 	}
-	showTimeLog()
-	fmt.Printf("DONE\n")
 
-	return nil
+	return result.show()
 }
 func setRecordUpdatable(record map[string]interface{}, columns Columns) {
 	for _, col := range columns {
@@ -325,40 +497,72 @@ func uploadFiles(app *kintone.App, value string) (kintone.FileField, error) {
 	}
 
 	files := strings.Split(value, "\n")
+	var paths []string
+	skipped := false
 	for _, file := range files {
-		var path string
-		if filepath.IsAbs(file) {
-			path = file
-		} else {
-			path = filepath.Join(config.FileDir, file)
+		err := checkFile(file)
+		if sizeErr, ok := err.(*FileSizeError); ok {
+			skipFile(sizeErr)
+			skipped = true
+			continue
 		}
-		fileKey, err := uploadFile(app, path)
 		if err != nil {
 			return nil, err
 		}
-		ret = append(ret, kintone.File{FileKey: fileKey})
+		ret = append(ret, kintone.File{})
+		paths = append(paths, file)
+	}
+	if skipped {
+		// the field is not imported, so that an update keeps the files attached before
+		return nil, nil
+	}
+	if !config.DryRun {
+		// the file keys are set before the bulkRequest is sent
+		getUploader(app).Add(ret, paths)
 	}
 	return ret, nil
 }
 
-func uploadFile(app *kintone.App, filePath string) (string, error) {
-	fi, err := os.Open(filePath)
-	if err != nil {
-		return "", err
+// getFilePath the path of the file written in the input file, for the messages
+func getFilePath(file string) string {
+	if filepath.IsAbs(file) {
+		return file
 	}
-	defer fi.Close()
+	return filepath.Join(config.FileDir, file)
+}
 
-	fileinfo, err := fi.Stat()
+// checkFile checks that the file of the path written in the input file can be uploaded
+func checkFile(file string) error {
+	source, err := getAttachmentSource()
+	if err != nil {
+		return err
+	}
+	fi, size, err := source.Open(file)
+	if err != nil {
+		return err
+	}
+	fi.Close()
+	return checkFileSize(getFilePath(file), size)
+}
 
+// uploadFile uploads the file of the path written in the input file, from the directory or the archive of "-b"
+func uploadFile(app *kintone.App, file string) (string, error) {
+	source, err := getAttachmentSource()
 	if err != nil {
 		return "", err
 	}
-
-	if fileinfo.Size() > 10*1024*1024 {
-		return "", fmt.Errorf("%s file must be less than 10 MB", filePath)
+	fi, _, err := source.Open(file)
+	if err != nil {
+		return "", err
 	}
+	defer fi.Close()
 
-	fileKey, err := app.Upload(path.Base(filePath), "application/octet-stream", fi)
+	fileName := path.Base(filepath.ToSlash(file))
+	head, reader, err := readHead(fi)
+	if err != nil {
+		return "", err
+	}
+	fileKey, err := app.Upload(fileName, getContentType(fileName, head), reader)
 	return fileKey, err
 }
 
@@ -401,12 +605,8 @@ func getField(fieldType string, value string) interface{} {
 		if value == "" {
 			return kintone.DateField{Valid: false}
 		}
-		dt, err := time.Parse("2006-01-02", value)
-		if err == nil {
-			return kintone.DateField{Date: dt, Valid: true}
-		}
-		dt, err = time.Parse("2006/1/2", value)
-		if err == nil {
+		dt, ok := parseTime(value, DATE_LAYOUTS)
+		if ok {
 			return kintone.DateField{Date: dt, Valid: true}
 		}
 
@@ -414,8 +614,8 @@ func getField(fieldType string, value string) interface{} {
 		if value == "" {
 			return kintone.TimeField{Valid: false}
 		}
-		dt, err := time.Parse("15:04:05", value)
-		if err == nil {
+		dt, ok := parseTime(value, TIME_LAYOUTS)
+		if ok {
 			return kintone.TimeField{Time: dt, Valid: true}
 		}
 
@@ -10,10 +10,10 @@ import (
 func TestImport1(t *testing.T) {
 	data := "Text,Text_Area,Rich_text\n11,22,<div>aaaaaa</div>\n111,22,<div>dddddqqddss</div>\n211,22,<div>aaaaaa</div>"
 
-	app := newApp()
+	app := newApp(t)
 
 	config.DeleteAll = true
-	err := importFromCSV(app, bytes.NewBufferString(data))
+	err := importFromCSV(app, bytes.NewBufferString(data), nil)
 	if err != nil {
 		t.Fatal(err)
 	}
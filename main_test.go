@@ -3,11 +3,42 @@ package main
 import (
 	"os"
 	"strconv"
+	"testing"
 
 	"github.com/kintone-labs/go-kintone"
 )
 
-func newApp() *kintone.App {
+// TEST_APP_ID the ID of the app of the tests in the fake kintone
+const TEST_APP_ID = 1
+
+// newTestApp the app of the tests, with the fields used by them
+func newTestApp() *fakeApp {
+	return &fakeApp{
+		ID:        TEST_APP_ID,
+		APITokens: []string{"test-api-token"},
+		Fields: []*kintone.FieldInfo{
+			{Code: "single_line_text", Type: kintone.FT_SINGLE_LINE_TEXT},
+			{Code: "multi_line_text", Type: kintone.FT_MULTI_LINE_TEXT},
+			{Code: "number", Type: kintone.FT_DECIMAL},
+			{Code: "table", Type: kintone.FT_SUBTABLE, Fields: []kintone.FieldInfo{
+				{Code: "table_single_line_text", Type: kintone.FT_SINGLE_LINE_TEXT},
+				{Code: "table_multi_line_text", Type: kintone.FT_MULTI_LINE_TEXT},
+			}},
+			{Code: "record_number", Type: kintone.FT_RECNUM},
+			{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT},
+			{Code: "Text_Area", Type: kintone.FT_MULTI_LINE_TEXT},
+			{Code: "Rich_text", Type: kintone.FT_RICH_TEXT},
+			{Code: "_2", Type: kintone.FT_SINGLE_LINE_TEXT},
+		},
+	}
+}
+
+// newApp the app of the tests in a fake kintone. With KINTONE_TEST_LIVE=1, the app of
+// KINTONE_DOMAIN and KINTONE_APP_ID instead, which must have the fields of newTestApp.
+func newApp(t *testing.T) *kintone.App {
+	if os.Getenv("KINTONE_TEST_LIVE") != "1" {
+		return newFakeKintone(t, newTestApp()).App(TEST_APP_ID)
+	}
 	appID, _ := strconv.ParseUint(os.Getenv("KINTONE_APP_ID"), 10, 64)
 
 	return &kintone.App{
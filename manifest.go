@@ -0,0 +1,228 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// ManifestEntry an attachment file of a record, written to the manifest of the export
+type ManifestEntry struct {
+	RecordID      uint64 `json:"$id"`
+	FieldCode     string `json:"fieldCode"`
+	SubtableRowID uint64 `json:"subtableRowId,omitempty"` // 0 if the field is not in a table
+	FileName      string `json:"fileName"`
+	Path          string `json:"path"` // relative to the directory of "-b"
+	Size          uint64 `json:"size"`
+	ContentType   string `json:"contentType"`
+	SHA256        string `json:"sha256"`
+	fileKey       string
+}
+
+// AttachmentManifest the list of the attachment files downloaded to the directory of "-b",
+// "manifest.csv" or "manifest.json" as the format of the export
+type AttachmentManifest struct {
+	file    *os.File
+	csv     *csv.Writer
+	entries uint64
+	pending []*ManifestEntry
+}
+
+var manifestHeader = []string{"$id", "fieldCode", "subtableRowId", "fileName", "path", "size", "contentType", "sha256"}
+
+func getManifestPath() string {
+	if isJSONFormat() {
+		return filepath.Join(config.FileDir, "manifest.json")
+	}
+	return filepath.Join(config.FileDir, "manifest.csv")
+}
+
+// openManifest creates the manifest, or appends to the manifest of the export resumed from the checkpoint.
+// The manifest of "--attachments-archive" is a temporary file, written into the archive by closeManifest.
+func openManifest() (*AttachmentManifest, error) {
+	var file *os.File
+	var err error
+	if config.AttachmentsArchive != "" {
+		file, err = ioutil.TempFile("", "manifest")
+	} else {
+		flag := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+		if config.Checkpoint != "" {
+			// truncated by loadExportCheckpoint
+			flag = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+		}
+		if err := os.MkdirAll(config.FileDir, 0777); err != nil {
+			return nil, err
+		}
+		file, err = os.OpenFile(getManifestPath(), flag, 0666)
+	}
+	if err != nil {
+		return nil, err
+	}
+	manifest := &AttachmentManifest{file: file, csv: csv.NewWriter(file)}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if info.Size() == 0 {
+		if isJSONFormat() {
+			_, err = fmt.Fprint(file, "[")
+		} else {
+			err = manifest.csv.Write(manifestHeader)
+			manifest.csv.Flush()
+		}
+	} else if isJSONFormat() {
+		// resumed, the separator of the next entry depends on the entries written before
+		manifest.entries, err = countManifestEntries(file.Name())
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// countManifestEntries the number of the entries of the JSON manifest written before the checkpoint
+func countManifestEntries(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	// the manifest is written up to the last entry
+	var entries []json.RawMessage
+	if err := json.Unmarshal(append(data, "\n]"...), &entries); err != nil {
+		return 0, fmt.Errorf("The manifest %s is broken: %v", path, err)
+	}
+	return uint64(len(entries)), nil
+}
+
+// Add adds the entry of a file, written by Flush when the file is downloaded
+func (manifest *AttachmentManifest) Add(entry *ManifestEntry) {
+	manifest.pending = append(manifest.pending, entry)
+}
+
+// Flush writes the entries added, with the size and the hash of the files in index
+func (manifest *AttachmentManifest) Flush(index map[string]*downloadedFile) error {
+	for _, entry := range manifest.pending {
+		saved := index[filepath.ToSlash(entry.Path)]
+		if saved == nil {
+			// downloaded by an export without the index
+			var err error
+			saved, err = getDownloadedFile(entry.Path)
+			if err != nil {
+				return err
+			}
+			saved.FileKey = entry.fileKey
+			index[filepath.ToSlash(entry.Path)] = saved
+		}
+		entry.Size = saved.Size
+		entry.SHA256 = saved.SHA256
+
+		if isJSONFormat() {
+			data, err := json.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			separator := "\n"
+			if manifest.entries > 0 {
+				separator = ",\n"
+			}
+			if _, err := fmt.Fprint(manifest.file, separator+string(data)); err != nil {
+				return err
+			}
+		} else {
+			subtableRowID := ""
+			if entry.SubtableRowID > 0 {
+				subtableRowID = strconv.FormatUint(entry.SubtableRowID, 10)
+			}
+			manifest.csv.Write([]string{strconv.FormatUint(entry.RecordID, 10), entry.FieldCode, subtableRowID,
+				entry.FileName, entry.Path, strconv.FormatUint(entry.Size, 10), entry.ContentType, entry.SHA256})
+		}
+		manifest.entries++
+	}
+	manifest.pending = nil
+	manifest.csv.Flush()
+	return manifest.csv.Error()
+}
+
+// Size the size of the manifest written so far
+func (manifest *AttachmentManifest) Size() int64 {
+	return getOutputSize(manifest.file)
+}
+
+// Close writes the end of the manifest
+func (manifest *AttachmentManifest) Close() error {
+	if isJSONFormat() {
+		if _, err := fmt.Fprint(manifest.file, "\n]\n"); err != nil {
+			manifest.file.Close()
+			return err
+		}
+	}
+	return manifest.file.Close()
+}
+
+// closeManifest writes the manifest into the archive, and closes the archive
+func closeManifest(manifest *AttachmentManifest, archive *AttachmentArchive) error {
+	defer os.Remove(manifest.file.Name())
+	err := manifest.Close()
+	if err == nil {
+		err = archive.AddFile(filepath.Base(getManifestPath()), manifest.file.Name())
+	}
+	if errClose := archive.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// getDownloadedFile the size and the hash of a file in the directory of "-b"
+func getDownloadedFile(path string) (*downloadedFile, error) {
+	file, err := os.Open(filepath.Join(config.FileDir, path))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, err
+	}
+	return &downloadedFile{Size: uint64(size), SHA256: hex.EncodeToString(hash.Sum(nil))}, nil
+}
+
+// getManifestSize the size of the manifest of the running export, 0 if no file was downloaded yet
+func getManifestSize() int64 {
+	if downloader == nil {
+		return 0
+	}
+	return downloader.manifest.Size()
+}
+
+// resetManifest removes the manifest of a previous export, or the entries written after the checkpoint
+func resetManifest(size int64) error {
+	if config.FileDir == "" {
+		return nil
+	}
+	path := getManifestPath()
+	if size == 0 {
+		err := os.Remove(path)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() > size {
+		return os.Truncate(path, size)
+	}
+	return nil
+}
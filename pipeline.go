@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// importBatch a bulkRequest of the records from firstRow to lastRow of the input file
+type importBatch struct {
+	seq      uint64
+	bulk     *BulkRequests
+	files    uploadBatch // the files of the records, uploaded before the bulkRequest is sent
+	firstRow uint64
+	lastRow  uint64
+	line     uint64 // line where the last record ends
+	offset   int64  // bytes of the input read up to the end of the last record
+	sent     bool
+	resp     *DataResponseBulkPOST
+	err      interface{}
+}
+
+// ImportPipeline sends the bulkRequests over concurrent workers while the input file is parsed.
+// The responses are handled in the order of the input file.
+type ImportPipeline struct {
+	app        *kintone.App
+	result     *importResult
+	rejects    *RejectWriter
+	checkpoint *ImportCheckpoint
+	batchSize  int
+	seq        uint64
+	batches    chan *importBatch
+	results    chan *importBatch
+	stopped    chan struct{}
+	stopOnce   sync.Once
+	workers    sync.WaitGroup
+	collected  chan error
+	closeOnce  sync.Once
+	err        error
+}
+
+func newImportPipeline(app *kintone.App, result *importResult, rejects *RejectWriter, checkpoint *ImportCheckpoint) *ImportPipeline {
+	concurrency := int(config.Concurrency)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	batchSize := ConstBulkRequestLimitRecordOption
+	if concurrency > 1 {
+		// full bulkRequests
+		batchSize = ConstBulkRequestLimitRequest * ConstRecordsLimitPerRequest
+	}
+	pipeline := &ImportPipeline{
+		app:        app,
+		result:     result,
+		rejects:    rejects,
+		checkpoint: checkpoint,
+		batchSize:  batchSize,
+		batches:    make(chan *importBatch, concurrency),
+		results:    make(chan *importBatch, concurrency),
+		stopped:    make(chan struct{}),
+		collected:  make(chan error, 1),
+	}
+	initClient(app)
+	for i := 0; i < concurrency; i++ {
+		pipeline.workers.Add(1)
+		go pipeline.work()
+	}
+	go func() {
+		pipeline.collected <- pipeline.collect()
+	}()
+	return pipeline
+}
+
+func (pipeline *ImportPipeline) stop() {
+	pipeline.stopOnce.Do(func() {
+		close(pipeline.stopped)
+	})
+}
+
+func (pipeline *ImportPipeline) isStopped() bool {
+	select {
+	case <-pipeline.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+func (pipeline *ImportPipeline) work() {
+	defer pipeline.workers.Done()
+	for batch := range pipeline.batches {
+		// the bulkRequests queued after an error are not sent
+		if !config.DryRun && !pipeline.isStopped() {
+			if err := batch.files.Wait(); err != nil {
+				batch.err = err
+			} else {
+				batch.resp, batch.err = batch.bulk.Request(pipeline.app)
+			}
+			batch.sent = true
+		}
+		pipeline.results <- batch
+	}
+}
+
+// IsFull reports whether the bulkRequest must be sent before the next record is added
+func (pipeline *ImportPipeline) IsFull(bulk *BulkRequests) bool {
+	return bulk.IsFull(pipeline.batchSize)
+}
+
+// Send sends the bulkRequest of the records from firstRow to lastRow. line and offset are the end of the last record.
+// It returns an error when the import was stopped by a previous bulkRequest.
+func (pipeline *ImportPipeline) Send(bulk *BulkRequests, firstRow, lastRow, line uint64, offset int64) error {
+	if len(bulk.Requests) == 0 {
+		return nil
+	}
+	pipeline.seq++
+	batch := &importBatch{seq: pipeline.seq, bulk: bulk, files: takeUploads(), firstRow: firstRow, lastRow: lastRow, line: line, offset: offset}
+	select {
+	case pipeline.batches <- batch:
+		return nil
+	case <-pipeline.stopped:
+		if err := pipeline.Close(); err != nil {
+			return err
+		}
+		return fmt.Errorf("The import was stopped")
+	}
+}
+
+// Close waits until all the bulkRequests are handled, and returns the error of the import
+func (pipeline *ImportPipeline) Close() error {
+	pipeline.closeOnce.Do(func() {
+		close(pipeline.batches)
+		pipeline.workers.Wait()
+		close(pipeline.results)
+		pipeline.err = <-pipeline.collected
+	})
+	return pipeline.err
+}
+
+// collect handles the responses in the order of the input file
+func (pipeline *ImportPipeline) collect() error {
+	pending := make(map[uint64]*importBatch)
+	next := uint64(1)
+	var failed *importBatch
+	var err error
+
+	for batch := range pipeline.results {
+		if batch.sent && batch.err == nil {
+			// committed, maybe before the previous bulkRequests
+			if errSave := pipeline.checkpoint.Commit(batch.firstRow, batch.lastRow); errSave != nil && err == nil {
+				err = errSave
+				pipeline.stop()
+			}
+		}
+		pending[batch.seq] = batch
+		for ; pending[next] != nil; next++ {
+			batch := pending[next]
+			delete(pending, next)
+			if failed != nil || err != nil {
+				pipeline.report(batch)
+				continue
+			}
+			// the records are not sent one by one if they may have been added
+			if batch.sent && batch.err != nil && (config.OnError != "continue" || !batch.bulk.canResend(batch.err)) {
+				failed = batch
+				pipeline.stop()
+				continue
+			}
+			if errHandle := pipeline.handle(batch); errHandle != nil {
+				err = errHandle
+				pipeline.stop()
+				continue
+			}
+			if errSave := pipeline.checkpoint.Save(batch.lastRow, batch.line, batch.offset); errSave != nil {
+				err = errSave
+				pipeline.stop()
+			}
+		}
+	}
+
+	if failed != nil {
+		// show the error, which is returned by Close to stop the import
+		showTimeLog()
+		fmt.Printf("Start from lines: %d - %d", failed.firstRow, failed.lastRow)
+		return failed.bulk.HandelResponse(failed.resp, failed.err, failed.firstRow, failed.lastRow)
+	}
+	return err
+}
+
+// handle the response of a bulkRequest, only count the records in dry-run mode
+func (pipeline *ImportPipeline) handle(batch *importBatch) error {
+	showTimeLog()
+	fmt.Printf("Start from lines: %d - %d", batch.firstRow, batch.lastRow)
+
+	inserted, updated := batch.bulk.CountRecords()
+	if config.DryRun {
+		fmt.Printf(" => DRY RUN: %d records will be inserted, %d records will be updated\n", inserted, updated)
+	} else if batch.err != nil {
+		fmt.Printf(" => ERROR OCCURRED, retry the records one by one\n")
+		return sendBulkRequestsEach(pipeline.app, batch.bulk, pipeline.result, pipeline.rejects)
+	} else {
+		fmt.Println(" => SUCCESS")
+	}
+	pipeline.result.Inserted += uint64(inserted)
+	pipeline.result.Updated += uint64(updated)
+	return nil
+}
+
+// report the result of a bulkRequest handled after the import was stopped
+func (pipeline *ImportPipeline) report(batch *importBatch) {
+	if !batch.sent {
+		return
+	}
+	showTimeLog()
+	fmt.Printf("Start from lines: %d - %d", batch.firstRow, batch.lastRow)
+	if batch.err != nil {
+		fmt.Printf(" => ERROR OCCURRED, not imported\n")
+		return
+	}
+	fmt.Printf(" => SUCCESS, already sent when the import was stopped\n")
+	inserted, updated := batch.bulk.CountRecords()
+	pipeline.result.Inserted += uint64(inserted)
+	pipeline.result.Updated += uint64(updated)
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+func TestImportPipeline(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.Concurrency = 4
+	fake := newFakeKintone(t, newTestApp())
+	app := fake.App(TEST_APP_ID)
+
+	checkpoint := &ImportCheckpoint{path: filepath.Join(dir, "import.checkpoint")}
+	result := &importResult{}
+	pipeline := newImportPipeline(app, result, nil, checkpoint)
+	for row := uint64(1); row <= 10; row++ {
+		bulk := &BulkRequests{}
+		bulk.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{"Text": kintone.SingleLineTextField(fmt.Sprint(row))}))
+		if err := pipeline.Send(bulk, row, row, row, int64(row)*10); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := pipeline.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.Requests) != 10 || len(fake.Records(TEST_APP_ID)) != 10 || result.Inserted != 10 {
+		t.Errorf("10 bulkRequests must be sent, %d sent, %d records inserted", len(fake.Requests), result.Inserted)
+	}
+	data, err := ioutil.ReadFile(checkpoint.path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var savedCheckpoint ImportCheckpoint
+	json.Unmarshal(data, &savedCheckpoint)
+	if savedCheckpoint.Row != 10 || savedCheckpoint.Offset != 100 || len(savedCheckpoint.Committed) != 0 {
+		t.Errorf("the checkpoint must be saved after the last record but %+v", savedCheckpoint)
+	}
+}
+
+func TestImportPipelineError(t *testing.T) {
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.Concurrency = 2
+	config.OnError = "stop"
+	testApp := newTestApp()
+	for _, field := range testApp.Fields {
+		if field.Code == "Text" {
+			field.Required = true
+		}
+	}
+	fake := newFakeKintone(t, testApp)
+	app := fake.App(TEST_APP_ID)
+
+	result := &importResult{}
+	pipeline := newImportPipeline(app, result, nil, nil)
+	for row := uint64(1); row <= 3; row++ {
+		value := "text"
+		if row == 2 {
+			value = ""
+		}
+		bulk := &BulkRequests{}
+		bulk.ImportDataInsert(app, kintone.NewRecord(map[string]interface{}{"Text": kintone.SingleLineTextField(value)}))
+		if err := pipeline.Send(bulk, row, row, row, int64(row)*10); err != nil {
+			break
+		}
+	}
+	// the error is returned to the goroutine of the import instead of exiting
+	if err := pipeline.Close(); err == nil {
+		t.Error("the error of the bulkRequest must be returned")
+	}
+	if result.Inserted < 1 {
+		t.Errorf("the records before the error must be counted but %d", result.Inserted)
+	}
+}
+
+func TestImportCheckpointCommitted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	checkpoint := &ImportCheckpoint{path: filepath.Join(dir, "import.checkpoint")}
+
+	checkpoint.Commit(201, 300)
+	checkpoint.Commit(1, 100)
+	checkpoint.Save(100, 100, 1000)
+	if len(checkpoint.Committed) != 1 || checkpoint.Committed[0].First != 201 {
+		t.Errorf("only the records committed after the checkpoint must be kept but %+v", checkpoint.Committed)
+	}
+
+	checkpoint.resumed = checkpoint.Committed
+	if !checkpoint.IsCommitted(250) || checkpoint.IsCommitted(150) {
+		t.Error("the records from 201 to 300 must be skipped")
+	}
+}
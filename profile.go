@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+// DEFAULT_PROFILE the profile used when "--profile" is not specified
+const DEFAULT_PROFILE = "default"
+
+// Profile a named connection profile of the config file
+type Profile struct {
+	Domain            string
+	AppID             uint64
+	GuestSpaceID      uint64
+	Auth              string // "password" or "api-token", from the credentials of the profile if it is not specified
+	Login             string
+	Password          string
+	APIToken          []string
+	BasicAuthUser     string
+	BasicAuthPassword string
+}
+
+// getConfigFilePath the config file of "--config", or ~/.config/cli-kintone/config.toml
+func getConfigFilePath() (string, error) {
+	if config.ConfigFile != "" {
+		return config.ConfigFile, nil
+	}
+	dir := os.Getenv("XDG_CONFIG_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".config")
+	}
+	return filepath.Join(dir, NAME, "config.toml"), nil
+}
+
+// loadProfile reads the profile of "--profile" from the config file.
+// Without "--profile", the profile "default" is read if it exists, and nil is returned otherwise.
+func loadProfile() (*Profile, error) {
+	path, err := getConfigFilePath()
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) && config.Profile == "" && config.ConfigFile == "" {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	profiles, err := readProfiles(file)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	name := config.Profile
+	if name == "" {
+		return profiles[DEFAULT_PROFILE], nil
+	}
+	profile := profiles[name]
+	if profile == nil {
+		return nil, fmt.Errorf("The profile %s is not found in %s", name, path)
+	}
+	return profile, nil
+}
+
+// readProfiles reads the tables [profiles.<name>] of a TOML config file
+func readProfiles(reader io.Reader) (map[string]*Profile, error) {
+	profiles := make(map[string]*Profile)
+	var profile *Profile
+	scanner := bufio.NewScanner(reader)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(removeTOMLComment(scanner.Text()))
+		if text == "" {
+			continue
+		}
+		if strings.HasPrefix(text, "[") {
+			if !strings.HasSuffix(text, "]") {
+				return nil, fmt.Errorf("line %d: invalid table %s", line, text)
+			}
+			table := strings.TrimSpace(text[1 : len(text)-1])
+			profile = nil
+			if !strings.HasPrefix(table, "profiles.") {
+				// not a profile
+				continue
+			}
+			name, err := parseTOMLKey(strings.TrimPrefix(table, "profiles."))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", line, err)
+			}
+			profile = &Profile{}
+			profiles[name] = profile
+			continue
+		}
+
+		index := strings.Index(text, "=")
+		if index < 0 {
+			return nil, fmt.Errorf("line %d: invalid line %s", line, text)
+		}
+		if profile == nil {
+			continue
+		}
+		key, err := parseTOMLKey(strings.TrimSpace(text[:index]))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+		if err := profile.set(key, strings.TrimSpace(text[index+1:])); err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for name, profile := range profiles {
+		if err := profile.check(); err != nil {
+			return nil, fmt.Errorf("profile %s: %v", name, err)
+		}
+	}
+	return profiles, nil
+}
+
+// set the value of a key of the profile
+func (profile *Profile) set(key, value string) error {
+	var err error
+	switch key {
+	case "domain":
+		profile.Domain, err = parseTOMLString(value)
+	case "app":
+		profile.AppID, err = strconv.ParseUint(value, 10, 64)
+	case "guest-space":
+		profile.GuestSpaceID, err = strconv.ParseUint(value, 10, 64)
+	case "auth":
+		profile.Auth, err = parseTOMLString(value)
+	case "username":
+		profile.Login, err = parseTOMLString(value)
+	case "password":
+		profile.Password, err = parseTOMLString(value)
+	case "api-token":
+		profile.APIToken, err = parseTOMLStrings(value)
+	case "basic-auth-user":
+		profile.BasicAuthUser, err = parseTOMLString(value)
+	case "basic-auth-password":
+		profile.BasicAuthPassword, err = parseTOMLString(value)
+	default:
+		return fmt.Errorf("unknown key %s", key)
+	}
+	if err != nil {
+		return fmt.Errorf("invalid value of %s: %s", key, value)
+	}
+	return nil
+}
+
+// check the auth method of the profile, and decide it from the credentials if it is not specified
+func (profile *Profile) check() error {
+	switch profile.Auth {
+	case "":
+		profile.Auth = "password"
+		if len(profile.APIToken) > 0 {
+			profile.Auth = "api-token"
+		}
+	case "password", "api-token":
+	default:
+		return fmt.Errorf("auth must be \"password\" or \"api-token\" but %s", profile.Auth)
+	}
+	return nil
+}
+
+// removeTOMLComment removes the comment at the end of the line, outside of the strings
+func removeTOMLComment(line string) string {
+	var quote rune
+	escaped := false
+	for i, c := range line {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// parseTOMLKey a bare or quoted key
+func parseTOMLKey(key string) (string, error) {
+	if strings.HasPrefix(key, "\"") || strings.HasPrefix(key, "'") {
+		return parseTOMLString(key)
+	}
+	if key == "" || strings.ContainsAny(key, " \t.\"'") {
+		return "", fmt.Errorf("invalid key %s", key)
+	}
+	return key, nil
+}
+
+// parseTOMLString a basic ("...") or literal ('...') string
+func parseTOMLString(value string) (string, error) {
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return value[1 : len(value)-1], nil
+	}
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		return strconv.Unquote(value)
+	}
+	return "", fmt.Errorf("invalid string %s", value)
+}
+
+// parseTOMLStrings a string, or an array of strings on a line
+func parseTOMLStrings(value string) ([]string, error) {
+	if !strings.HasPrefix(value, "[") {
+		s, err := parseTOMLString(value)
+		return []string{s}, err
+	}
+	if !strings.HasSuffix(value, "]") {
+		return nil, fmt.Errorf("invalid array %s", value)
+	}
+	var values []string
+	var quote rune
+	escaped := false
+	items := value[1 : len(value)-1]
+	start := 0
+	for i, c := range items + "," {
+		switch {
+		case escaped:
+			escaped = false
+		case quote == '"' && c == '\\':
+			escaped = true
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			item := strings.TrimSpace((items + ",")[start:i])
+			start = i + 1
+			if item == "" {
+				// a comma at the end
+				continue
+			}
+			s, err := parseTOMLString(item)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, s)
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("invalid array %s", value)
+	}
+	return values, nil
+}
+
+// getOptions the options of the group and of its sub groups
+func getOptions(group *flags.Group) []*flags.Option {
+	options := group.Options()
+	for _, subgroup := range group.Groups() {
+		options = append(options, getOptions(subgroup)...)
+	}
+	return options
+}
+
+// applyProfile sets the connection options of the profile which are not specified by the command line
+// or by the environment variables. The credentials of the profile are used only if none is specified.
+func applyProfile(options []*flags.Option) error {
+	profile, err := loadProfile()
+	if err != nil || profile == nil {
+		return err
+	}
+	specified := make(map[string]bool)
+	for _, option := range options {
+		name := option.Field().Name
+		if option.IsSet() && !option.IsSetDefault() {
+			specified[name] = true
+		} else if _, ok := os.LookupEnv(option.EnvDefaultKey); ok && option.EnvDefaultKey != "" {
+			specified[name] = true
+		}
+	}
+
+	if !specified["Domain"] && profile.Domain != "" {
+		config.Domain = profile.Domain
+	}
+	if !specified["AppID"] && profile.AppID != 0 {
+		config.AppID = profile.AppID
+	}
+	if !specified["GuestSpaceID"] && profile.GuestSpaceID != 0 {
+		config.GuestSpaceID = profile.GuestSpaceID
+	}
+	if !specified["BasicAuthUser"] && profile.BasicAuthUser != "" {
+		config.BasicAuthUser = profile.BasicAuthUser
+		if !specified["BasicAuthPassword"] {
+			config.BasicAuthPassword = profile.BasicAuthPassword
+		}
+	}
+	if specified["Login"] || specified["Password"] || specified["APIToken"] ||
+		specified["PasswordFile"] || specified["PasswordStdin"] || specified["APITokenFile"] {
+		return nil
+	}
+	if profile.Auth == "api-token" {
+		config.APIToken = profile.APIToken
+	} else {
+		config.Login = profile.Login
+		config.Password = profile.Password
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	flags "github.com/jessevdk/go-flags"
+)
+
+const testConfigFile = `# profiles of cli-kintone
+[profiles.default]
+domain = "dev.cybozu.com"
+app = 1
+username = "dev"
+password = "dev#password" # a comment
+
+[profiles.prod-orders]
+domain = 'example.cybozu.com'
+app = 123
+guest-space = 4
+api-token = ["token", 'lookup,token', ]
+basic-auth-user = "basic"
+basic-auth-password = "basic-password"
+
+[other]
+key = "value"
+`
+
+func TestReadProfiles(t *testing.T) {
+	profiles, err := readProfiles(strings.NewReader(testConfigFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(profiles) != 2 {
+		t.Fatalf("2 profiles must be read but %d", len(profiles))
+	}
+	dev := profiles["default"]
+	if dev.Domain != "dev.cybozu.com" || dev.AppID != 1 || dev.Auth != "password" || dev.Password != "dev#password" {
+		t.Errorf("wrong profile %+v", dev)
+	}
+	prod := profiles["prod-orders"]
+	if prod.Domain != "example.cybozu.com" || prod.AppID != 123 || prod.GuestSpaceID != 4 || prod.Auth != "api-token" ||
+		len(prod.APIToken) != 2 || prod.APIToken[1] != "lookup,token" || prod.BasicAuthUser != "basic" || prod.BasicAuthPassword != "basic-password" {
+		t.Errorf("wrong profile %+v", prod)
+	}
+
+	invalids := []string{
+		"[profiles.a]\nunknown = 1\n",
+		"[profiles.a]\napp = \"1\"\n",
+		"[profiles.a]\ndomain = example\n",
+		"[profiles.a]\nauth = \"oauth\"\n",
+		"[profiles.a\n",
+		"[profiles.a]\napi-token = [\"a\", b]\n",
+	}
+	for _, invalid := range invalids {
+		if _, err := readProfiles(strings.NewReader(invalid)); err == nil {
+			t.Errorf("%q must be an error", invalid)
+		}
+	}
+}
+
+func TestApplyProfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "profile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.toml")
+	if err := ioutil.WriteFile(path, []byte(testConfigFile), 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer func() { config = Configure{} }()
+	// the environment of the tests with a live app
+	for _, key := range []string{"KINTONE_DOMAIN", "KINTONE_APP_ID", "KINTONE_USERNAME", "KINTONE_PASSWORD", "KINTONE_API_TOKEN"} {
+		if value, ok := os.LookupEnv(key); ok {
+			os.Unsetenv(key)
+			defer os.Setenv(key, value)
+		}
+	}
+
+	parse := func(args ...string) error {
+		setDefaultOptions()
+		parser := newCommandParser()
+		parser.CommandHandler = func(cmd flags.Commander, args []string) error {
+			cmd.(command).apply()
+			return applyProfile(getOptions(parser.Active.Group))
+		}
+		_, err := parser.ParseArgs(append(args, "--config", path))
+		return err
+	}
+
+	// the profile "default" without --profile
+	if err := parse("count"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Domain != "dev.cybozu.com" || config.AppID != 1 || config.Login != "dev" || getAPIToken() != "" {
+		t.Errorf("wrong options of the default profile %+v", config.ConnectionOptions)
+	}
+
+	// the options and the environment variables override the profile
+	os.Setenv("KINTONE_APP_ID", "7")
+	if err := parse("export", "--profile", "prod-orders", "-d", "other.cybozu.com"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Domain != "other.cybozu.com" || config.AppID != 7 || config.GuestSpaceID != 4 || getAPIToken() != "token,lookup" || config.BasicAuthUser != "basic" {
+		t.Errorf("wrong options of the profile %+v", config.ConnectionOptions)
+	}
+
+	// the credentials of the options replace those of the profile
+	os.Unsetenv("KINTONE_APP_ID")
+	if err := parse("export", "--profile", "prod-orders", "-u", "user"); err != nil {
+		t.Fatal(err)
+	}
+	if config.Login != "user" || getAPIToken() != "" {
+		t.Errorf("wrong credentials %+v", config.ConnectionOptions)
+	}
+
+	if err := parse("export", "--profile", "unknown"); err == nil {
+		t.Error("an unknown profile must be an error")
+	}
+}
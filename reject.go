@@ -0,0 +1,126 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"os"
+)
+
+const (
+	// REJECT_CODE_COLUMN column of the reject file for the error code
+	REJECT_CODE_COLUMN = "#error_code"
+	// REJECT_MESSAGE_COLUMN column of the reject file for the error message
+	REJECT_MESSAGE_COLUMN = "#error_message"
+)
+
+// RejectWriter writes the rejected records to the reject file.
+// The rows are written as they are in the input file, so the reject file can be fixed and imported again.
+// A nil RejectWriter writes nothing.
+type RejectWriter struct {
+	file      *os.File
+	writer    io.Writer
+	csvWriter *csv.Writer
+	header    []string
+	// the columns of the error, kept at the same place when a reject file is imported again
+	codeIndex     int
+	messageIndex  int
+	headerWritten bool
+}
+
+func isRejectColumn(code string) bool {
+	return code == REJECT_CODE_COLUMN || code == REJECT_MESSAGE_COLUMN
+}
+
+func newRejectWriter(path string) (*RejectWriter, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	writer := getWriter(file)
+	return &RejectWriter{file: file, writer: writer, csvWriter: csv.NewWriter(writer)}, nil
+}
+
+// SetHeader set the header row of the CSV file, with the columns of the error
+func (rejects *RejectWriter) SetHeader(header []string) {
+	if rejects == nil {
+		return
+	}
+	rejects.header = append([]string{}, header...)
+	rejects.codeIndex, rejects.messageIndex = -1, -1
+	for i, code := range header {
+		switch code {
+		case REJECT_CODE_COLUMN:
+			rejects.codeIndex = i
+		case REJECT_MESSAGE_COLUMN:
+			rejects.messageIndex = i
+		}
+	}
+	if rejects.codeIndex < 0 {
+		rejects.codeIndex = len(rejects.header)
+		rejects.header = append(rejects.header, REJECT_CODE_COLUMN)
+	}
+	if rejects.messageIndex < 0 {
+		rejects.messageIndex = len(rejects.header)
+		rejects.header = append(rejects.header, REJECT_MESSAGE_COLUMN)
+	}
+}
+
+// Write writes the source of a rejected record with the error
+func (rejects *RejectWriter) Write(source *BulkRequestSource, code, message string) error {
+	if rejects == nil || source == nil {
+		return nil
+	}
+
+	if source.Data != nil {
+		var record map[string]interface{}
+		if err := json.Unmarshal(source.Data, &record); err != nil {
+			return err
+		}
+		record[REJECT_CODE_COLUMN] = map[string]string{"value": code}
+		record[REJECT_MESSAGE_COLUMN] = map[string]string{"value": message}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		_, err = rejects.writer.Write(append(data, '\n'))
+		return err
+	}
+
+	if !rejects.headerWritten {
+		if err := rejects.csvWriter.Write(rejects.header); err != nil {
+			return err
+		}
+		rejects.headerWritten = true
+	}
+	for i, row := range source.Rows {
+		// all the rows have the columns of the header, so that the file can be imported again
+		cells := make([]string, len(rejects.header))
+		copy(cells, row)
+		cells[rejects.codeIndex], cells[rejects.messageIndex] = "", ""
+		if i == 0 {
+			cells[rejects.codeIndex], cells[rejects.messageIndex] = code, message
+		}
+		if err := rejects.csvWriter.Write(cells); err != nil {
+			return err
+		}
+	}
+	rejects.csvWriter.Flush()
+	return rejects.csvWriter.Error()
+}
+
+// Close close the reject file
+func (rejects *RejectWriter) Close() error {
+	if rejects == nil {
+		return nil
+	}
+	rejects.csvWriter.Flush()
+	if closer, ok := rejects.writer.(io.Closer); ok && rejects.writer != io.Writer(rejects.file) {
+		// flush the encoder
+		closer.Close()
+	}
+	return rejects.file.Close()
+}
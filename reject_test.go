@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRejectWriterCSV(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-kintone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config.Encoding = "utf-8"
+	path := filepath.Join(dir, "rejects.csv")
+	rejects, err := newRejectWriter(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rejects.SetHeader([]string{"*", "Text", "Table", "Table_text"})
+	source := &BulkRequestSource{Row: 2, Lines: []uint64{2, 3}, Rows: [][]string{
+		{"*", "aaa", "", "bbb"},
+		{"", "aaa", "", "ccc"},
+	}}
+	if err := rejects.Write(source, "CB_VA01", "Invalid input."); err != nil {
+		t.Fatal(err)
+	}
+	if err := rejects.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "*,Text,Table,Table_text,#error_code,#error_message\n" +
+		"*,aaa,,bbb,CB_VA01,Invalid input.\n" +
+		",aaa,,ccc,,\n"
+	if string(data) != expected {
+		t.Errorf("Invalid reject file:\n%s", data)
+	}
+}
+
+func TestImportRejectFileAgain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cli-kintone")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() { config = saved }()
+	fake := newFakeKintone(t, newTestApp())
+	app := fake.App(TEST_APP_ID)
+	config = Configure{}
+	config.AppID = TEST_APP_ID
+	config.Line = 1
+	config.Encoding = "utf-8"
+	config.OnError = "continue"
+	config.RejectFile = filepath.Join(dir, "rejects.csv")
+
+	input := "*,Text,number,table,table_single_line_text\n" +
+		"*,aaa,1,,a1\n" +
+		",,,,a2\n" +
+		"*,bbb,abc,,b1\n" +
+		",,,,b2\n"
+	if err := importFromCSV(app, strings.NewReader(input), nil); err == nil {
+		t.Fatal("the invalid record must be rejected")
+	}
+	data, err := ioutil.ReadFile(config.RejectFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(bytes.NewReader(data)).ReadAll()
+	if err != nil {
+		t.Fatalf("the reject file must be a valid CSV file: %v\n%s", err, data)
+	}
+	if len(rows) != 3 || rows[1][5] != "CB_VA01" || rows[2][5] != "" {
+		t.Fatalf("the rejected record must be written with its error:\n%s", data)
+	}
+
+	// fixed and imported as it is
+	fixed := strings.Replace(string(data), "abc", "2", 1)
+	config.RejectFile = filepath.Join(dir, "rejects2.csv")
+	if err := importFromCSV(app, strings.NewReader(fixed), nil); err != nil {
+		t.Fatal(err)
+	}
+	records := fake.Records(TEST_APP_ID)
+	if len(records) != 2 {
+		t.Fatalf("the fixed record must be imported, %d records", len(records))
+	}
+	if table, ok := records[1]["table"].Value.([]fakeRow); !ok || len(table) != 2 {
+		t.Errorf("the rows of the table must be imported: %v", records[1]["table"].Value)
+	}
+}
+
+func TestNilRejectWriter(t *testing.T) {
+	rejects, err := newRejectWriter("")
+	if err != nil || rejects != nil {
+		t.Fatal("RejectWriter must be nil without path")
+	}
+	if err := rejects.Write(&BulkRequestSource{}, "", ""); err != nil {
+		t.Error(err)
+	}
+	if err := rejects.Close(); err != nil {
+		t.Error(err)
+	}
+}
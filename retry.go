@@ -0,0 +1,321 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// RetryPolicy settings of the retry of the requests which failed by a transient error
+type RetryPolicy struct {
+	MaxAttempts uint
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	Timeout     time.Duration // timeout of each attempt
+}
+
+func newRetryPolicy() *RetryPolicy {
+	return &RetryPolicy{
+		MaxAttempts: config.RetryMaxAttempts,
+		Backoff:     config.RetryBackoff,
+		MaxBackoff:  config.RetryMaxBackoff,
+		Jitter:      config.RetryJitter,
+		Timeout:     config.Timeout,
+	}
+}
+
+// Attempts the number of attempts, at least 1
+func (policy *RetryPolicy) Attempts() uint {
+	if policy.MaxAttempts < 1 {
+		return 1
+	}
+	return policy.MaxAttempts
+}
+
+// Wait the wait before the next attempt. retryAfter is the value of the Retry-After header, or 0
+func (policy *RetryPolicy) Wait(attempt uint, retryAfter time.Duration) time.Duration {
+	wait := policy.Backoff
+	for i := uint(1); i < attempt && wait < policy.MaxBackoff; i++ {
+		wait *= 2
+	}
+	if policy.MaxBackoff > 0 && wait > policy.MaxBackoff {
+		wait = policy.MaxBackoff
+	}
+	if policy.Jitter > 0 {
+		wait -= time.Duration(float64(wait) * policy.Jitter * rand.Float64())
+	}
+	if retryAfter > wait {
+		wait = retryAfter
+	}
+	return wait
+}
+
+// TotalTimeout the timeout of a request including all the attempts
+func (policy *RetryPolicy) TotalTimeout() time.Duration {
+	timeout := policy.Timeout
+	if timeout == 0 {
+		timeout = kintone.DEFAULT_TIMEOUT
+	}
+	return time.Duration(policy.Attempts()) * (timeout + policy.MaxBackoff)
+}
+
+// parse the Retry-After header (seconds or HTTP date)
+func getRetryAfter(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	value := strings.TrimSpace(resp.Header.Get("Retry-After"))
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date)
+	}
+	return 0
+}
+
+// isIdempotentRequest reports whether the request can be sent again when it is unknown if kintone processed it.
+// A DELETE sent again fails if the first one deleted the records. bulkRequest is sent again by BulkRequests.Request.
+func isIdempotentRequest(req *http.Request) bool {
+	switch req.Method {
+	case "POST":
+		return strings.HasSuffix(req.URL.Path, "/file.json") || strings.HasSuffix(req.URL.Path, "/records/cursor.json")
+	case "DELETE":
+		return false
+	}
+	return true
+}
+
+// isRetryable reports whether the request failed by a transient error and can be sent again
+func isRetryable(req *http.Request, resp *http.Response, err error) bool {
+	if err != nil {
+		// connection reset, timeout, etc.
+		return isIdempotentRequest(req)
+	}
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		// the request was not processed
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return isIdempotentRequest(req)
+	}
+	return false
+}
+
+// RetryTransport sends the requests again when they failed by a transient error
+type RetryTransport struct {
+	Transport http.RoundTripper
+	Policy    *RetryPolicy
+}
+
+// cancelBody cancels the context of the attempt when the body is closed
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (body *cancelBody) Close() error {
+	err := body.ReadCloser.Close()
+	body.cancel()
+	return err
+}
+
+// RoundTrip implements http.RoundTripper
+func (t *RetryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	transport := t.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	attempts := t.Policy.Attempts()
+
+	for attempt := uint(1); ; attempt++ {
+		attemptReq := req
+		if attempt > 1 && req.Body != nil && req.Body != http.NoBody {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if t.Policy.Timeout > 0 {
+			ctx, cancel = context.WithTimeout(req.Context(), t.Policy.Timeout)
+		} else {
+			ctx, cancel = context.WithCancel(req.Context())
+		}
+
+		resp, err := transport.RoundTrip(attemptReq.WithContext(ctx))
+		canRetry := attempt < attempts && (req.Body == nil || req.GetBody != nil)
+		if !canRetry || !isRetryable(req, resp, err) {
+			if err != nil {
+				cancel()
+				if ctx.Err() == context.DeadlineExceeded {
+					return nil, kintone.ErrTimeout
+				}
+				return nil, err
+			}
+			resp.Body = &cancelBody{resp.Body, cancel}
+			return resp, nil
+		}
+
+		wait := t.Policy.Wait(attempt, getRetryAfter(resp))
+		if err != nil {
+			log.Printf("Retry %d/%d in %v: %s %s: %v", attempt, attempts-1, wait, req.Method, req.URL.Path, err)
+		} else {
+			log.Printf("Retry %d/%d in %v: %s %s: %s", attempt, attempts-1, wait, req.Method, req.URL.Path, resp.Status)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		cancel()
+
+		select {
+		case <-time.After(wait):
+		case <-req.Context().Done():
+			return nil, req.Context().Err()
+		}
+	}
+}
+
+// isAmbiguousError reports whether it is unknown if kintone processed the request which failed with the error
+func isAmbiguousError(err interface{}) bool {
+	switch e := err.(type) {
+	case *BulkRequestsError, *BulkRequestsErrors, *FileUploadError:
+		return false
+	case *kintone.AppError:
+		switch e.HttpStatusCode {
+		case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+	return err != kintone.ErrInvalidResponse
+}
+
+// getLastRecordID get the largest $id of the app, 0 if the app has no records
+func getLastRecordID(app *kintone.App) (uint64, error) {
+	records, err := app.GetRecords([]string{"$id"}, "order by $id desc limit 1")
+	if err != nil {
+		return 0, err
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+	return records[0].Id(), nil
+}
+
+func escapeQueryValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(value)
+}
+
+// duplicateQuery the query to find the record if it was added after lastID.
+// It returns "" if the record has no value which can be searched.
+func duplicateQuery(record *kintone.Record, lastID uint64) string {
+	codes := make([]string, 0, len(record.Fields))
+	for code := range record.Fields {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+
+	conditions := []string{fmt.Sprintf("$id > %d", lastID)}
+	for _, code := range codes {
+		var value string
+		switch field := record.Fields[code].(type) {
+		case kintone.SingleLineTextField:
+			value = string(field)
+		case kintone.DecimalField:
+			value = string(field)
+		case kintone.LinkField:
+			value = string(field)
+		default:
+			continue
+		}
+		if value != "" {
+			conditions = append(conditions, fmt.Sprintf("%s = \"%s\"", code, escapeQueryValue(value)))
+		}
+	}
+	if len(conditions) == 1 {
+		return ""
+	}
+	return strings.Join(conditions, " and ") + " limit 1"
+}
+
+// isAdded checks whether the records of the request exist after lastID, the first and the last ones are enough.
+// The records which cannot be searched are counted instead: added is the number of records the bulkRequest adds.
+func isAdded(app *kintone.App, payload *DataRequestRecordsPOST, lastID uint64, added int) (bool, error) {
+	checks := []*kintone.Record{payload.Records[0]}
+	if len(payload.Records) > 1 {
+		checks = append(checks, payload.Records[len(payload.Records)-1])
+	}
+	for _, record := range checks {
+		query := duplicateQuery(record, lastID)
+		if query == "" {
+			count, err := countRecords(app, fmt.Sprintf("$id > %d", lastID))
+			if err != nil {
+				return false, err
+			}
+			return count >= uint64(added), nil
+		}
+		records, err := app.GetRecords([]string{"$id"}, query)
+		if err != nil {
+			return false, err
+		}
+		if len(records) == 0 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// removeAdded moves the requests whose records were added after lastID to Committed, so that they are not sent again.
+// A bulkRequest is all or nothing: when all the records were added, the requests updating records were processed too.
+func (bulk *BulkRequests) removeAdded(app *kintone.App, lastID uint64) error {
+	inserted, _ := countRequestRecords(bulk.Requests)
+	pending := make([]*BulkRequestItem, 0, len(bulk.Requests))
+	committed := make([]*BulkRequestItem, 0)
+	for _, bulkReqItem := range bulk.Requests {
+		payload, ok := bulkReqItem.Payload.(*DataRequestRecordsPOST)
+		if !ok || len(payload.Records) == 0 {
+			pending = append(pending, bulkReqItem)
+			continue
+		}
+		added, err := isAdded(app, payload, lastID, inserted)
+		if err != nil {
+			return err
+		}
+		if added {
+			committed = append(committed, bulkReqItem)
+		} else {
+			pending = append(pending, bulkReqItem)
+		}
+	}
+	if remaining, _ := countRequestRecords(pending); remaining == 0 && len(committed) > 0 {
+		committed = append(committed, pending...)
+		pending = pending[:0]
+	}
+	bulk.Requests = pending
+	bulk.Committed = append(bulk.Committed, committed...)
+	return nil
+}
+
+// canResend reports whether the bulkRequest which failed with the error can be sent again.
+// When it is unknown if kintone processed it, a bulkRequest adding records is sent again only if its records were not found.
+func (bulk *BulkRequests) canResend(err interface{}) bool {
+	inserted, _ := countRequestRecords(bulk.Requests)
+	return inserted == 0 || bulk.notAdded || !isAmbiguousError(err)
+}
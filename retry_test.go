@@ -0,0 +1,170 @@
+package main
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// newRetryTestRequest a request to the fake kintone, with the API token of the app of the tests
+func newRetryTestRequest(fake *fakeKintone, method, path, body string) *http.Request {
+	req, _ := http.NewRequest(method, fake.server.URL+path, strings.NewReader(body))
+	req.Header.Set("X-Cybozu-API-Token", "test-api-token")
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return req
+}
+
+func newRetryTestClient() *http.Client {
+	policy := &RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, MaxBackoff: 10 * time.Millisecond, Jitter: 0.5}
+	return &http.Client{Transport: &RetryTransport{Policy: policy}}
+}
+
+const retryTestBulkRequest = `{"requests": [{"method": "POST", "api": "/k/v1/records.json", "payload": {"app": 1, "records": [{"Text": {"value": "aaa"}}]}}]}`
+
+func TestRetryTransport(t *testing.T) {
+	fake := newFakeKintone(t, newTestApp())
+	fake.Failures = map[string][]int{"POST /k/v1/bulkRequest.json": {http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+
+	resp, err := newRetryTestClient().Do(newRetryTestRequest(fake, "POST", "/k/v1/bulkRequest.json", retryTestBulkRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || len(fake.Requests) != 3 || len(fake.Records(TEST_APP_ID)) != 1 {
+		t.Errorf("the request must succeed at the 3rd attempt, status %d after %d attempts", resp.StatusCode, len(fake.Requests))
+	}
+}
+
+func TestRetryTransportMaxAttempts(t *testing.T) {
+	fake := newFakeKintone(t, newTestApp())
+	fake.Failures = map[string][]int{"GET /k/v1/records.json": {http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}}
+
+	resp, err := newRetryTestClient().Do(newRetryTestRequest(fake, "GET", "/k/v1/records.json?app=1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || len(fake.Requests) != 3 {
+		t.Errorf("the request must fail after 3 attempts, status %d after %d attempts", resp.StatusCode, len(fake.Requests))
+	}
+}
+
+func TestRetryTransportAmbiguousPOST(t *testing.T) {
+	fake := newFakeKintone(t, newTestApp())
+	fake.Failures = map[string][]int{"POST /k/v1/bulkRequest.json": {http.StatusBadGateway}}
+
+	resp, err := newRetryTestClient().Do(newRetryTestRequest(fake, "POST", "/k/v1/bulkRequest.json", retryTestBulkRequest))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || len(fake.Requests) != 1 {
+		t.Errorf("the bulkRequest must not be sent again, status %d after %d attempts", resp.StatusCode, len(fake.Requests))
+	}
+}
+
+func TestRetryPolicyWait(t *testing.T) {
+	policy := &RetryPolicy{Backoff: time.Second, MaxBackoff: 5 * time.Second}
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, wait := range expected {
+		if actual := policy.Wait(uint(i+1), 0); actual != wait {
+			t.Errorf("wait of attempt %d must be %v but %v", i+1, wait, actual)
+		}
+	}
+	if actual := policy.Wait(1, 10*time.Second); actual != 10*time.Second {
+		t.Errorf("Retry-After must be honored but %v", actual)
+	}
+}
+
+func TestRetryTransportAmbiguousDELETE(t *testing.T) {
+	fake := newFakeKintone(t, newTestApp())
+	fake.Failures = map[string][]int{"DELETE /k/v1/records.json": {http.StatusBadGateway}}
+
+	resp, err := newRetryTestClient().Do(newRetryTestRequest(fake, "DELETE", "/k/v1/records.json?app=1&ids[0]=1", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadGateway || len(fake.Requests) != 1 {
+		t.Errorf("the records must not be deleted again, status %d after %d attempts", resp.StatusCode, len(fake.Requests))
+	}
+}
+
+func TestBulkRequestRetry(t *testing.T) {
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.RetryMaxAttempts = 3
+	config.RetryBackoff = time.Millisecond
+	config.RetryMaxBackoff = 10 * time.Millisecond
+
+	fake := newFakeKintone(t, newTestApp())
+	app := fake.App(TEST_APP_ID)
+	statuses := []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway}
+	newInsert := func(fields map[string]interface{}) *BulkRequests {
+		bulk := &BulkRequests{}
+		bulk.ImportDataInsert(app, kintone.NewRecord(fields))
+		return bulk
+	}
+
+	// the records are not found, sent again
+	fake.Failures = map[string][]int{"POST /k/v1/bulkRequest.json": statuses[:1]}
+	bulk := newInsert(map[string]interface{}{"Text": kintone.SingleLineTextField("aaa")})
+	if _, err := bulk.Request(app); err != nil || len(fake.Records(TEST_APP_ID)) != 1 {
+		t.Errorf("the records which were not added must be sent again, %d records: %v", len(fake.Records(TEST_APP_ID)), err)
+	}
+	expected := []string{"GET /k/v1/records.json", "POST /k/v1/bulkRequest.json", "GET /k/v1/records.json", "POST /k/v1/bulkRequest.json"}
+	if !reflect.DeepEqual(fake.Requests, expected) {
+		t.Errorf("the requests must be %v but %v", expected, fake.Requests)
+	}
+
+	// the records are found, not sent again
+	fake.Lost = map[string][]int{"POST /k/v1/bulkRequest.json": statuses[:1]}
+	bulk = newInsert(map[string]interface{}{"Text": kintone.SingleLineTextField(`say "bbb"`)})
+	if _, err := bulk.Request(app); err != nil || len(fake.Records(TEST_APP_ID)) != 2 {
+		t.Errorf("the records which were added must not be sent again, %d records: %v", len(fake.Records(TEST_APP_ID)), err)
+	}
+	if inserted, _ := bulk.CountRecords(); inserted != 1 || len(bulk.Requests) != 0 {
+		t.Errorf("the added records must be counted, %d records and %d requests to send", inserted, len(bulk.Requests))
+	}
+
+	// the records without a value to search are counted
+	fake.Lost = map[string][]int{"POST /k/v1/bulkRequest.json": statuses[:1]}
+	bulk = newInsert(map[string]interface{}{"Text_Area": kintone.MultiLineTextField("ccc")})
+	if _, err := bulk.Request(app); err != nil || len(fake.Records(TEST_APP_ID)) != 3 {
+		t.Errorf("the counted records must not be sent again, %d records: %v", len(fake.Records(TEST_APP_ID)), err)
+	}
+
+	// the records are not found after the last attempt, they can be sent one by one
+	fake.Failures = map[string][]int{"POST /k/v1/bulkRequest.json": statuses}
+	bulk = newInsert(map[string]interface{}{"Text": kintone.SingleLineTextField("ddd")})
+	if _, err := bulk.Request(app); err == nil || !bulk.canResend(err) {
+		t.Errorf("the records which were not added must be sent one by one: %v", err)
+	}
+
+	// the records cannot be searched, not sent again
+	fake.Lost = map[string][]int{"POST /k/v1/bulkRequest.json": statuses[:1]}
+	fake.Failures = map[string][]int{"GET /k/v1/records.json": {http.StatusBadRequest}}
+	fake.Requests = nil
+	bulk = newInsert(map[string]interface{}{"Text": kintone.SingleLineTextField("eee")})
+	if _, err := bulk.Request(app); err == nil || bulk.canResend(err) || len(fake.Requests) != 2 {
+		t.Errorf("the records which may be added must not be sent again, %d requests: %v", len(fake.Requests), err)
+	}
+
+	fake.Requests = nil
+	fake.Failures = map[string][]int{"POST /k/v1/bulkRequest.json": statuses}
+	bulk = &BulkRequests{}
+	bulk.ImportDataUpdate(app, kintone.NewRecordWithId(1, map[string]interface{}{"Text": kintone.SingleLineTextField("aaa")}), "")
+	if _, err := bulk.Request(app); err == nil || len(fake.Requests) != 3 {
+		t.Errorf("the records to update must be sent again, %d requests: %v", len(fake.Requests), err)
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// FileSizeError the attachment file is larger than "--max-file-size"
+type FileSizeError struct {
+	Path string
+	Size int64
+}
+
+func (e *FileSizeError) Error() string {
+	return fmt.Sprintf("%s (%d bytes) is larger than %d MB", e.Path, e.Size, config.MaxFileSize)
+}
+
+// skippedFiles the attachment files not uploaded by the import, shown with the result
+var skippedFiles struct {
+	sync.Mutex
+	errors []*FileSizeError
+}
+
+// skipFile reports the file which is not uploaded
+func skipFile(err *FileSizeError) {
+	skippedFiles.Lock()
+	defer skippedFiles.Unlock()
+	skippedFiles.errors = append(skippedFiles.errors, err)
+	showTimeLog()
+	fmt.Printf("SKIP FILE: %v\n", err)
+}
+
+// showSkippedFiles shows the files not uploaded since the last call, and returns an error if any
+func showSkippedFiles() error {
+	skippedFiles.Lock()
+	errors := skippedFiles.errors
+	skippedFiles.errors = nil
+	skippedFiles.Unlock()
+	if len(errors) == 0 {
+		return nil
+	}
+	fmt.Printf("%d attachment files were not uploaded:\n", len(errors))
+	for _, err := range errors {
+		fmt.Printf("  %v\n", err)
+	}
+	if config.DryRun {
+		return nil
+	}
+	return fmt.Errorf("%d attachment files were not uploaded", len(errors))
+}
+
+// checkFileSize checks the size of the file with "--max-file-size" before it is uploaded
+func checkFileSize(filePath string, size int64) error {
+	if config.MaxFileSize > 0 && size > int64(config.MaxFileSize)*1024*1024 {
+		return &FileSizeError{Path: filePath, Size: size}
+	}
+	return nil
+}
+
+// getContentType the content type of the file from its extension, or from its first 512 bytes
+func getContentType(name string, head []byte) string {
+	if contentType := mime.TypeByExtension(path.Ext(name)); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
+
+// readHead reads the first 512 bytes of the file to detect its content type, and returns the whole file
+func readHead(reader io.Reader) ([]byte, io.Reader, error) {
+	head := make([]byte, 512)
+	n, err := io.ReadFull(reader, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, nil, err
+	}
+	head = head[:n]
+	return head, io.MultiReader(bytes.NewReader(head), reader), nil
+}
+
+// fileUpload an attachment file uploaded once for all the fields of a record which refer to it
+type fileUpload struct {
+	file    string // the path written in the input file
+	record  *recordFiles
+	done    chan struct{}
+	fileKey string
+	err     error
+}
+
+// fileRef a file of a record, whose file key is set when the file is uploaded
+type fileRef struct {
+	field  kintone.FileField
+	index  int
+	upload *fileUpload
+}
+
+// recordFiles the files of a record. The file key of an upload can be used by one record only,
+// so the fields of the record which refer to the same file share an upload, but the other records do not.
+type recordFiles struct {
+	refs     []*fileRef
+	uploads  map[string]*fileUpload // path => upload
+	contents map[string]*fileUpload // name and SHA-256 => upload
+	err      error                  // the first error of the uploads, set by Wait
+}
+
+// FileUploadError the attachment files of records were not uploaded, and the bulkRequest was not sent
+type FileUploadError struct {
+	Err error
+}
+
+func (e *FileUploadError) Error() string {
+	return e.Err.Error()
+}
+
+// uploadBatch the files of the records of a bulkRequest
+type uploadBatch []*recordFiles
+
+// Wait waits until the files are uploaded and sets their file keys to the records.
+// It returns a FileUploadError with the first error, the error of each record is set to the record.
+func (batch uploadBatch) Wait() error {
+	var err error
+	for _, record := range batch {
+		for _, ref := range record.refs {
+			<-ref.upload.done
+			if ref.upload.err != nil {
+				if record.err == nil {
+					record.err = fmt.Errorf("%s: %v", getFilePath(ref.upload.file), ref.upload.err)
+				}
+				continue
+			}
+			ref.field[ref.index].FileKey = ref.upload.fileKey
+		}
+		if record.err != nil && err == nil {
+			err = &FileUploadError{record.err}
+		}
+	}
+	return err
+}
+
+// Uploader uploads the attachment files of the import with concurrent workers, while the input file is parsed.
+// Each file of a record is uploaded once: the fields which refer to the same path, or to a file with the same name
+// and the same content, reuse its file key. A file referred to by several records is uploaded for each of them,
+// but it is hashed once.
+type Uploader struct {
+	app     *kintone.App
+	jobs    chan *fileUpload
+	workers sync.WaitGroup
+	mu      sync.Mutex
+	closed  bool
+	record  *recordFiles      // the files of the record being parsed
+	records uploadBatch       // the files of the records parsed since the last Take
+	hashes  map[string]string // path => SHA-256, for all the records
+}
+
+// uploader the uploader of the running import, started by the first file
+var uploader *Uploader
+
+func getUploader(app *kintone.App) *Uploader {
+	if uploader != nil {
+		return uploader
+	}
+	concurrency := int(config.Concurrency)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	if concurrency > EXPORT_CONCURRENCY_LIMIT {
+		concurrency = EXPORT_CONCURRENCY_LIMIT
+	}
+	uploader = &Uploader{
+		app:    app,
+		jobs:   make(chan *fileUpload, DOWNLOAD_QUEUE_SIZE),
+		hashes: make(map[string]string),
+	}
+	initClient(app)
+	for i := 0; i < concurrency; i++ {
+		uploader.workers.Add(1)
+		go uploader.work()
+	}
+	return uploader
+}
+
+// Add queues the files of a field of the record being parsed, paths are the paths written in the input file
+func (u *Uploader) Add(field kintone.FileField, paths []string) {
+	for i, file := range paths {
+		key := filepath.Clean(file)
+		u.mu.Lock()
+		if u.record == nil {
+			u.record = &recordFiles{uploads: make(map[string]*fileUpload), contents: make(map[string]*fileUpload)}
+		}
+		upload, ok := u.record.uploads[key]
+		if !ok {
+			upload = &fileUpload{file: file, record: u.record, done: make(chan struct{})}
+			u.record.uploads[key] = upload
+		}
+		u.record.refs = append(u.record.refs, &fileRef{field: field, index: i, upload: upload})
+		u.mu.Unlock()
+		if !ok {
+			u.jobs <- upload
+		}
+	}
+}
+
+// EndRecord ends the record being parsed, and returns its files, nil without them
+func (u *Uploader) EndRecord() *recordFiles {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	record := u.record
+	if record != nil {
+		u.records = append(u.records, record)
+		u.record = nil
+	}
+	return record
+}
+
+// Take the files of the records parsed since the last call, to be uploaded before the bulkRequest is sent
+func (u *Uploader) Take() uploadBatch {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	records := u.records
+	u.records = nil
+	return records
+}
+
+// Close stops the workers, the files queued are not uploaded any more
+func (u *Uploader) Close() {
+	u.mu.Lock()
+	u.closed = true
+	u.mu.Unlock()
+	close(u.jobs)
+	u.workers.Wait()
+}
+
+func (u *Uploader) work() {
+	defer u.workers.Done()
+	for upload := range u.jobs {
+		u.mu.Lock()
+		closed := u.closed
+		u.mu.Unlock()
+		if closed {
+			upload.err = fmt.Errorf("The import was stopped")
+		} else {
+			upload.fileKey, upload.err = u.upload(upload)
+		}
+		close(upload.done)
+	}
+}
+
+// upload the file, unless a file of the same record with the same name and the same content was uploaded
+func (u *Uploader) upload(upload *fileUpload) (string, error) {
+	hash, err := u.getHash(upload.file)
+	if err != nil {
+		return "", err
+	}
+	key := path.Base(filepath.ToSlash(upload.file)) + "\x00" + hash
+	u.mu.Lock()
+	same, ok := upload.record.contents[key]
+	if !ok {
+		upload.record.contents[key] = upload
+	}
+	u.mu.Unlock()
+	if ok {
+		<-same.done
+		return same.fileKey, same.err
+	}
+	return uploadFile(u.app, upload.file)
+}
+
+// getHash the SHA-256 of the file of the path written in the input file, read once for all the records
+func (u *Uploader) getHash(file string) (string, error) {
+	key := filepath.Clean(file)
+	u.mu.Lock()
+	hash, ok := u.hashes[key]
+	u.mu.Unlock()
+	if ok {
+		return hash, nil
+	}
+	hash, err := getAttachmentHash(file)
+	if err != nil {
+		return "", err
+	}
+	u.mu.Lock()
+	u.hashes[key] = hash
+	u.mu.Unlock()
+	return hash, nil
+}
+
+// endRecordUploads ends the files of the record parsed, nil without them
+func endRecordUploads() *recordFiles {
+	if uploader == nil {
+		return nil
+	}
+	return uploader.EndRecord()
+}
+
+// takeUploads the files of the records parsed since the last call
+func takeUploads() uploadBatch {
+	if uploader == nil {
+		return nil
+	}
+	return uploader.Take()
+}
+
+// closeUploader stops the workers of the uploader at the end of the import
+func closeUploader() {
+	if uploader == nil {
+		return
+	}
+	uploader.Close()
+	uploader = nil
+}
+
+// getAttachmentHash the SHA-256 of the file of the path written in the input file
+func getAttachmentHash(file string) (string, error) {
+	source, err := getAttachmentSource()
+	if err != nil {
+		return "", err
+	}
+	reader, _, err := source.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer reader.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
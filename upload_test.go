@@ -0,0 +1,225 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+func TestGetContentType(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+	tests := []struct {
+		name     string
+		head     []byte
+		expected string
+	}{
+		{"report.pdf", []byte("%PDF-1.4"), "application/pdf"},
+		{"photo.png", png, "image/png"},
+		{"photo", png, "image/png"},
+		{"data.unknown-extension", []byte{0, 1, 2, 3}, "application/octet-stream"},
+	}
+	for _, test := range tests {
+		if actual := getContentType(test.name, test.head); actual != test.expected {
+			t.Errorf("content type of %s must be %s but %s", test.name, test.expected, actual)
+		}
+	}
+}
+
+func TestReadHead(t *testing.T) {
+	content := strings.Repeat("a", 1000)
+	head, reader, err := readHead(strings.NewReader(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _ := ioutil.ReadAll(reader)
+	if len(head) != 512 || string(data) != content {
+		t.Errorf("the whole file must be read after the head, %d bytes of head, %d bytes read", len(head), len(data))
+	}
+}
+
+func TestCheckFileSize(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.MaxFileSize = 1
+	if err := checkFileSize("a.txt", 1024*1024); err != nil {
+		t.Errorf("a file of 1 MB must be uploaded but %v", err)
+	}
+	if _, ok := checkFileSize("a.txt", 1024*1024+1).(*FileSizeError); !ok {
+		t.Error("a file larger than 1 MB must be skipped")
+	}
+	config.MaxFileSize = 0
+	if err := checkFileSize("a.txt", 1<<40); err != nil {
+		t.Errorf("the size must not be limited with 0 but %v", err)
+	}
+}
+
+// newUploadTestApp the app of the tests with the attachment fields
+func newUploadTestApp() *fakeApp {
+	app := newTestApp()
+	app.Fields = append(app.Fields,
+		&kintone.FieldInfo{Code: "Attachment", Type: kintone.FT_FILE},
+		&kintone.FieldInfo{Code: "Attachment_2", Type: kintone.FT_FILE})
+	return app
+}
+
+func TestUploader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.FileDir = dir
+	config.Concurrency = 4
+	os.Mkdir(filepath.Join(dir, "b"), 0777)
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("same"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b", "a.txt"), []byte("same"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "c.pdf"), []byte("%PDF-1.4"), 0644)
+	fake := newFakeKintone(t, newUploadTestApp())
+	app := fake.App(TEST_APP_ID)
+
+	uploader = nil
+	attachmentsOnce = sync.Once{}
+	defer func() {
+		closeUploader()
+		attachmentsOnce = sync.Once{}
+	}()
+	// the fields of 3 records
+	values := [][]string{{"a.txt"}, {"a.txt\nc.pdf", "a.txt"}, {filepath.Join("b", "a.txt")}}
+	var records []*kintone.Record
+	var fields []kintone.FileField
+	for _, record := range values {
+		recordFields := make(map[string]interface{})
+		for i, value := range record {
+			field, err := uploadFiles(app, value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			recordFields[[]string{"Attachment", "Attachment_2"}[i]] = field
+			fields = append(fields, field)
+		}
+		endRecordUploads()
+		records = append(records, kintone.NewRecord(recordFields))
+	}
+	if err := takeUploads().Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	uploaded := 0
+	for _, request := range fake.Requests {
+		if request == "POST /k/v1/file.json" {
+			uploaded++
+		}
+	}
+	if uploaded != 4 {
+		t.Errorf("each file must be uploaded once for each record, but %d uploads", uploaded)
+	}
+	if len(uploader.hashes) != 3 {
+		t.Errorf("each path must be hashed once for all the records, but %d hashes", len(uploader.hashes))
+	}
+	if file := fake.uploads[fields[1][1].FileKey]; file == nil || file.ContentType != "application/pdf" {
+		t.Errorf("the file must be uploaded with its content type: %v", file)
+	}
+	key := fields[1][0].FileKey
+	if key == "" || fields[2][0].FileKey != key || fields[0][0].FileKey == key || fields[3][0].FileKey == key {
+		t.Errorf("the file key must be reused only by the same record but %v", fields)
+	}
+	// a file key can be used by one record only
+	if _, err := app.AddRecords(records); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Records(TEST_APP_ID)) != 3 || len(fake.uploads) != 0 {
+		t.Errorf("the records must be added with all their files, %d uploads left", len(fake.uploads))
+	}
+}
+
+func TestUploadFilesSkipped(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.FileDir = dir
+	config.MaxFileSize = 1
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("small"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "b.bin"), make([]byte, 1024*1024+1), 0644)
+	fake := newFakeKintone(t, newUploadTestApp())
+	app := fake.App(TEST_APP_ID)
+
+	uploader = nil
+	attachmentsOnce = sync.Once{}
+	defer func() {
+		closeUploader()
+		attachmentsOnce = sync.Once{}
+		showSkippedFiles()
+	}()
+	// an update must keep the files attached before
+	field, err := uploadFiles(app, "a.txt\nb.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	endRecordUploads()
+	if err := takeUploads().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if field != nil || len(fake.Requests) != 0 {
+		t.Errorf("the field with a skipped file must not be imported, %v and %d requests", field, len(fake.Requests))
+	}
+}
+
+func TestImportUploadError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	saved := config
+	defer func() {
+		config = saved
+		resetTransport()
+	}()
+	config = Configure{}
+	config.AppID = TEST_APP_ID
+	config.Line = 1
+	config.FileDir = dir
+	config.OnError = "continue"
+	config.RetryMaxAttempts = 1
+	ioutil.WriteFile(filepath.Join(dir, "a.txt"), []byte("a"), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "c.pdf"), []byte("%PDF-1.4"), 0644)
+	fake := newFakeKintone(t, newUploadTestApp())
+	fake.Failures = map[string][]int{"POST /k/v1/file.json": {http.StatusBadRequest}}
+	attachmentsOnce = sync.Once{}
+	defer func() { attachmentsOnce = sync.Once{} }()
+
+	data := "Text,Attachment\naaa,a.txt\nbbb,c.pdf\n"
+	if err := importFromCSV(fake.App(TEST_APP_ID), strings.NewReader(data), nil); err == nil {
+		t.Error("the record whose file was not uploaded must be rejected")
+	}
+	if uploader != nil {
+		t.Error("the uploader must be closed at the end of the import")
+	}
+	records := fake.Records(TEST_APP_ID)
+	if len(records) != 1 || records[0]["Text"].Value != "bbb" {
+		t.Fatalf("only the record whose file was uploaded must be imported: %v", records)
+	}
+	if files := records[0]["Attachment"].Value.([]fakeFileValue); len(files) != 1 {
+		t.Errorf("the record must be imported with its file: %v", files)
+	}
+}
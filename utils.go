@@ -2,8 +2,8 @@ package main
 
 import (
 	"bufio"
-	"log"
 	"io"
+	"log"
 )
 
 func removeBOMCharacter(reader io.Reader) io.Reader {
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+// VALIDATION_ERROR_LIMIT The maximum number of validation errors will be shown
+const VALIDATION_ERROR_LIMIT = 100
+
+// ValidationError error of a value in the input file
+type ValidationError struct {
+	Row     uint64
+	Column  int
+	Code    string
+	Message string
+}
+
+func (err *ValidationError) Error() string {
+	if err.Column < 0 {
+		return fmt.Sprintf("row[%d] - field[%s]: %s", err.Row, err.Code, err.Message)
+	}
+	return fmt.Sprintf("row[%d] - column[%d] (%s): %s", err.Row, err.Column, err.Code, err.Message)
+}
+
+// Validator checks the values of the input file with the field settings of the app.
+// A nil Validator accepts everything.
+type Validator struct {
+	fields  map[string]*kintone.FieldInfo
+	uniques map[string]map[string]uint64
+	Errors  []*ValidationError
+}
+
+func newValidator(fields map[string]*kintone.FieldInfo) *Validator {
+	return &Validator{fields: fields, uniques: make(map[string]map[string]uint64)}
+}
+
+// get the field setting of a field or a field in a subtable
+func getFieldInfo(code string, fields map[string]*kintone.FieldInfo) *kintone.FieldInfo {
+	for _, val := range fields {
+		if val.Code == code {
+			return val
+		}
+		if val.Type == kintone.FT_SUBTABLE {
+			for _, subField := range val.Fields {
+				if subField.Code == code {
+					fieldInfo := subField
+					return &fieldInfo
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (v *Validator) addError(row uint64, index int, code, message string) {
+	v.Errors = append(v.Errors, &ValidationError{Row: row, Column: index, Code: code, Message: message})
+}
+
+// CheckColumn checks that the column of the input file is a field of the app
+func (v *Validator) CheckColumn(row uint64, index int, column *Column) {
+	if v == nil || column.Code == "" || isRejectColumn(column.Code) {
+		return
+	}
+	if column.Type == "UNKNOWN" {
+		v.addError(row, index, column.Code, "the field code is not found in the app")
+	}
+}
+
+// CheckCell checks a value of the input file. index is -1 if the input has no column.
+// The unique fields are checked within the input file only, the values of the records of the app are checked by kintone.
+func (v *Validator) CheckCell(row uint64, index int, column *Column, value string) {
+	if v == nil {
+		return
+	}
+	if column.Type == "UNKNOWN" {
+		if index < 0 {
+			v.CheckColumn(row, index, column)
+		}
+		return
+	}
+	fieldInfo := getFieldInfo(column.Code, v.fields)
+	if fieldInfo == nil {
+		return
+	}
+
+	if value == "" {
+		// the files are not sent without "-b", the field is checked by CheckRequired
+		if fieldInfo.Required && !column.IsSubField && isFieldSent(fieldInfo) {
+			v.addError(row, index, column.Code, "the value is required")
+		}
+		return
+	}
+
+	if message := validateValue(fieldInfo, value); message != "" {
+		v.addError(row, index, column.Code, message)
+		return
+	}
+
+	if fieldInfo.Unique {
+		values := v.uniques[column.Code]
+		if values == nil {
+			values = make(map[string]uint64)
+			v.uniques[column.Code] = values
+		}
+		if firstRow, ok := values[value]; ok {
+			v.addError(row, index, column.Code, fmt.Sprintf("the value must be unique, it is also used in row[%d]", firstRow))
+		} else {
+			values[value] = row
+		}
+	}
+}
+
+// CheckRequired checks that the required fields are in the record to insert. codes are the fields of the record
+func (v *Validator) CheckRequired(row uint64, codes map[string]bool) {
+	if v == nil {
+		return
+	}
+	missing := make([]string, 0)
+	for _, fieldInfo := range v.fields {
+		if fieldInfo.Required && (!codes[fieldInfo.Code] || !isFieldSent(fieldInfo)) {
+			missing = append(missing, fieldInfo.Code)
+		}
+	}
+	sort.Strings(missing)
+	for _, code := range missing {
+		if codes[code] {
+			v.addError(row, -1, code, "the value is required, but the files are not uploaded without the -b option")
+		} else {
+			v.addError(row, -1, code, "the value is required, but the field is not in the input file")
+		}
+	}
+}
+
+// isFieldSent reports whether the value of the field in the input file is sent to kintone,
+// the files are uploaded only with "-b"
+func isFieldSent(fieldInfo *kintone.FieldInfo) bool {
+	return fieldInfo.Type != kintone.FT_FILE || config.FileDir != ""
+}
+
+// Show shows the validation errors and returns an error if there is any
+func (v *Validator) Show() error {
+	if v == nil || len(v.Errors) == 0 {
+		return nil
+	}
+	for i, err := range v.Errors {
+		if i == VALIDATION_ERROR_LIMIT {
+			fmt.Printf("... and %d more errors\n", len(v.Errors)-i)
+			break
+		}
+		fmt.Println(err.Error())
+	}
+	return fmt.Errorf("%d validation errors found in the input file. No records were imported", len(v.Errors))
+}
+
+// get the numeric limit of a field setting
+func getFieldLimit(limit interface{}) (float64, bool) {
+	if limit == nil {
+		return 0, false
+	}
+	value, err := strconv.ParseFloat(strings.TrimSpace(fmt.Sprint(limit)), 64)
+	return value, err == nil
+}
+
+func containsOptions(options []string, values []string) string {
+	for _, value := range values {
+		if !containtString(options, value) {
+			return fmt.Sprintf("'%s' is not an option of the field", value)
+		}
+	}
+	return ""
+}
+
+// validateValue returns the error message if the value is invalid for the field
+// DATE_LAYOUTS the formats of the values of the date fields, for the import and its validation
+var DATE_LAYOUTS = []string{"2006-01-02", "2006/1/2"}
+
+// TIME_LAYOUTS the formats of the values of the time fields, for the import and its validation
+var TIME_LAYOUTS = []string{"15:04:05", "15:04"}
+
+// parseTime parses the value with the first of the layouts which matches
+func parseTime(value string, layouts []string) (time.Time, bool) {
+	for _, layout := range layouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+func validateValue(fieldInfo *kintone.FieldInfo, value string) string {
+	switch fieldInfo.Type {
+	case kintone.FT_DECIMAL:
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Sprintf("'%s' is not a number", value)
+		}
+		if min, ok := getFieldLimit(fieldInfo.MinValue); ok && number < min {
+			return fmt.Sprintf("the value must be %v or more", fieldInfo.MinValue)
+		}
+		if max, ok := getFieldLimit(fieldInfo.MaxValue); ok && number > max {
+			return fmt.Sprintf("the value must be %v or less", fieldInfo.MaxValue)
+		}
+	case kintone.FT_SINGLE_LINE_TEXT, kintone.FT_MULTI_LINE_TEXT, kintone.FT_LINK:
+		length := float64(utf8.RuneCountInString(value))
+		if min, ok := getFieldLimit(fieldInfo.MinLength); ok && length < min {
+			return fmt.Sprintf("the value must be %v characters or more", fieldInfo.MinLength)
+		}
+		if max, ok := getFieldLimit(fieldInfo.MaxLength); ok && length > max {
+			return fmt.Sprintf("the value must be %v characters or less", fieldInfo.MaxLength)
+		}
+	case kintone.FT_RADIO, kintone.FT_SINGLE_SELECT:
+		return containsOptions(fieldInfo.Options, []string{value})
+	case kintone.FT_CHECK_BOX, kintone.FT_MULTI_SELECT:
+		return containsOptions(fieldInfo.Options, strings.Split(value, "\n"))
+	case kintone.FT_DATE:
+		if _, ok := parseTime(value, DATE_LAYOUTS); !ok {
+			return fmt.Sprintf("'%s' is not a date (YYYY-MM-DD)", value)
+		}
+	case kintone.FT_TIME:
+		if _, ok := parseTime(value, TIME_LAYOUTS); !ok {
+			return fmt.Sprintf("'%s' is not a time (HH:MM:SS or HH:MM)", value)
+		}
+	case kintone.FT_DATETIME, kintone.FT_CTIME, kintone.FT_MTIME:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Sprintf("'%s' is not a date and time (RFC3339)", value)
+		}
+	}
+	return ""
+}
+
+// validateData checks every record of the input with the field settings of the app, without sending any record
+func validateData(app *kintone.App, reader io.Reader) error {
+	fields, err := getFields(app)
+	if err != nil {
+		return err
+	}
+	validator := newValidator(fields)
+
+	format := config.Format
+	if format == "" {
+		format, reader = detectJSONFormat(reader)
+	}
+	switch format {
+	case "json":
+		var readRecord func() (json.RawMessage, uint64, int64, error)
+		if readRecord, err = readJSONDocument(reader); err == nil {
+			err = validateJSONRecords(readRecord, fields, validator)
+		}
+	case "jsonl":
+		input := &countReader{reader: getReader(reader)}
+		err = validateJSONRecords(readJSONLines(input, bufio.NewReader(input), 0), fields, validator)
+	default:
+		err = validateCSV(reader, fields, validator)
+	}
+	if err != nil {
+		return err
+	}
+	return validator.Show()
+}
+
+// validateCSV checks the records of the CSV input like importFromCSV reads them
+func validateCSV(reader io.Reader, fields map[string]*kintone.FieldInfo, validator *Validator) error {
+	csvReader := csv.NewReader(getReader(reader))
+	header, err := csvReader.Read()
+	if err == io.EOF {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	columns := make(Columns, 0, len(header))
+	keyField := ""
+	hasTable := false
+	for i, col := range header {
+		re := regexp.MustCompile("^(.*)\\[(.*)\\]$")
+		if match := re.FindStringSubmatch(col); match != nil {
+			columns = append(columns, &Column{Code: match[1], Type: match[2]})
+			continue
+		}
+		if len(col) > 0 && col[0] == '*' {
+			col = col[1:]
+			keyField = col
+		}
+		column := getColumn(col, fields)
+		if column.IsSubField && (header[0] == "" || header[0] == "*") {
+			hasTable = true
+		}
+		validator.CheckColumn(1, i, column)
+		columns = append(columns, column)
+	}
+
+	// the fields of the input, for the records to insert
+	codes := make(map[string]bool)
+	for _, column := range columns {
+		if !column.IsSubField {
+			codes[column.Code] = true
+		}
+	}
+
+	var rowNumber uint64 = 1
+	isInsert := false
+	for {
+		row, err := csvReader.Read()
+		if err != nil && err != io.EOF {
+			return err
+		}
+		// a row of the subtable continues the record
+		if err == nil && rowNumber > 1 && hasTable && len(row) > 0 && row[0] != "*" {
+			validateCSVRow(rowNumber, row, columns, keyField, hasTable, validator)
+			continue
+		}
+		if isInsert {
+			validator.CheckRequired(rowNumber, codes)
+		}
+		if err == io.EOF {
+			return nil
+		}
+		rowNumber++
+		isInsert = false
+		if rowNumber < config.Line {
+			continue
+		}
+		isInsert = validateCSVRow(rowNumber, row, columns, keyField, hasTable, validator)
+	}
+}
+
+// validateCSVRow checks the values of a row, and reports whether it is the first row of a record to insert
+func validateCSVRow(rowNumber uint64, row []string, columns Columns, keyField string, hasTable bool, validator *Validator) bool {
+	if rowNumber < config.Line {
+		return false
+	}
+	isInsert := true
+	for i, col := range row {
+		if i >= len(columns) {
+			break
+		}
+		column := columns[i]
+		if column.IsSubField {
+			validator.CheckCell(rowNumber, i, column, col)
+			continue
+		}
+		if column.Type == kintone.FT_SUBTABLE || (hasTable && row[0] != "*") {
+			continue
+		}
+		isKey := keyField != "" && column.Code == keyField
+		switch {
+		case column.Code == "$id":
+			if col != "" {
+				isInsert = false
+			}
+		case column.Code == "$revision" || (isKey && col == ""):
+		default:
+			if isKey {
+				isInsert = false
+			}
+			validator.CheckCell(rowNumber, i, column, col)
+		}
+	}
+	return isInsert && (!hasTable || row[0] == "*")
+}
+
+// validateJSONRecords checks the records of the JSON input like importJSONRecords reads them
+func validateJSONRecords(readRecord func() (json.RawMessage, uint64, int64, error), fields map[string]*kintone.FieldInfo, validator *Validator) error {
+	for rowNumber := uint64(1); ; rowNumber++ {
+		raw, _, _, err := readRecord()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if rowNumber < config.Line {
+			continue
+		}
+		var data jsonRecord
+		if err := json.Unmarshal(raw, &data); err != nil {
+			return fmt.Errorf("\nrecord[%d]: %v", rowNumber, err)
+		}
+		if err := validateJSONRecord(rowNumber, data, fields, validator); err != nil {
+			return fmt.Errorf("\nrecord[%d]: %v", rowNumber, err)
+		}
+	}
+}
+
+func validateJSONRecord(rowNumber uint64, data jsonRecord, fields map[string]*kintone.FieldInfo, validator *Validator) error {
+	codes := make([]string, 0, len(data))
+	for code, field := range data {
+		if field != nil {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+
+	present := make(map[string]bool)
+	isInsert := true
+	for _, code := range codes {
+		field := data[code]
+		value, err := jsonFieldString(field.Value)
+		if err != nil {
+			return fmt.Errorf("field[%s]: %v", code, err)
+		}
+		if code == "$id" {
+			if value != "" {
+				isInsert = false
+			}
+			continue
+		} else if code == "$revision" {
+			continue
+		}
+
+		column := getColumn(code, fields)
+		if column.IsSubField {
+			continue
+		}
+		present[code] = true
+		validator.CheckCell(rowNumber, -1, column, value)
+		if column.Type != kintone.FT_SUBTABLE || len(field.Value) == 0 {
+			continue
+		}
+		var rows []*jsonSubTableRow
+		if err := json.Unmarshal(field.Value, &rows); err != nil {
+			return fmt.Errorf("field[%s]: %v", code, err)
+		}
+		for _, row := range rows {
+			if row == nil {
+				continue
+			}
+			for subCode, subField := range row.Value {
+				subColumn := getColumn(subCode, fields)
+				if subField == nil || !subColumn.IsSubField {
+					continue
+				}
+				col, err := jsonFieldString(subField.Value)
+				if err != nil {
+					return fmt.Errorf("field[%s]: %v", code, err)
+				}
+				validator.CheckCell(rowNumber, -1, subColumn, col)
+			}
+		}
+	}
+	if isInsert {
+		validator.CheckRequired(rowNumber, present)
+	}
+	return nil
+}
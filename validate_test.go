@@ -0,0 +1,216 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/kintone-labs/go-kintone"
+)
+
+func newValidatorTest() *Validator {
+	return newValidator(map[string]*kintone.FieldInfo{
+		"Text":     {Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT, Required: true, Unique: true, MaxLength: "5"},
+		"Number":   {Code: "Number", Type: kintone.FT_DECIMAL, MinValue: "0", MaxValue: "100"},
+		"Date":     {Code: "Date", Type: kintone.FT_DATE},
+		"Radio":    {Code: "Radio", Type: kintone.FT_RADIO, Options: []string{"a", "b"}},
+		"Checkbox": {Code: "Checkbox", Type: kintone.FT_CHECK_BOX, Options: []string{"a", "b"}},
+		"Table": {Code: "Table", Type: kintone.FT_SUBTABLE, Fields: []kintone.FieldInfo{
+			{Code: "Table_time", Type: kintone.FT_TIME},
+		}},
+	})
+}
+
+func TestValidatorValidValues(t *testing.T) {
+	validator := newValidatorTest()
+	validator.CheckCell(2, 0, &Column{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT}, "abc")
+	validator.CheckCell(2, 1, &Column{Code: "Number", Type: kintone.FT_DECIMAL}, "12.5")
+	validator.CheckCell(2, 2, &Column{Code: "Date", Type: kintone.FT_DATE}, "2020/1/2")
+	validator.CheckCell(2, 3, &Column{Code: "Radio", Type: kintone.FT_RADIO}, "b")
+	validator.CheckCell(2, 4, &Column{Code: "Checkbox", Type: kintone.FT_CHECK_BOX}, "a\nb")
+	validator.CheckCell(2, 5, &Column{Code: "Table_time", Type: kintone.FT_TIME, IsSubField: true, Table: "Table"}, "10:30")
+
+	if err := validator.Show(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidatorInvalidValues(t *testing.T) {
+	validator := newValidatorTest()
+	validator.CheckColumn(1, 6, &Column{Code: "Unknown", Type: "UNKNOWN"})
+	validator.CheckCell(2, 0, &Column{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT}, "")
+	validator.CheckCell(3, 0, &Column{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT}, "abcdef")
+	validator.CheckCell(4, 0, &Column{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT}, "abc")
+	validator.CheckCell(5, 0, &Column{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT}, "abc")
+	validator.CheckCell(2, 1, &Column{Code: "Number", Type: kintone.FT_DECIMAL}, "1o")
+	validator.CheckCell(3, 1, &Column{Code: "Number", Type: kintone.FT_DECIMAL}, "101")
+	validator.CheckCell(2, 2, &Column{Code: "Date", Type: kintone.FT_DATE}, "2020-13-01")
+	validator.CheckCell(2, 3, &Column{Code: "Radio", Type: kintone.FT_RADIO}, "c")
+	validator.CheckCell(2, 4, &Column{Code: "Checkbox", Type: kintone.FT_CHECK_BOX}, "a\nc")
+	validator.CheckCell(2, 5, &Column{Code: "Table_time", Type: kintone.FT_TIME, IsSubField: true, Table: "Table"}, "25:00")
+
+	expected := []string{
+		"row[1] - column[6] (Unknown): the field code is not found in the app",
+		"row[2] - column[0] (Text): the value is required",
+		"row[3] - column[0] (Text): the value must be 5 characters or less",
+		"row[5] - column[0] (Text): the value must be unique, it is also used in row[4]",
+		"row[2] - column[1] (Number): '1o' is not a number",
+		"row[3] - column[1] (Number): the value must be 100 or less",
+		"row[2] - column[2] (Date): '2020-13-01' is not a date (YYYY-MM-DD)",
+		"row[2] - column[3] (Radio): 'c' is not an option of the field",
+		"row[2] - column[4] (Checkbox): 'c' is not an option of the field",
+		"row[2] - column[5] (Table_time): '25:00' is not a time (HH:MM:SS or HH:MM)",
+	}
+	if len(validator.Errors) != len(expected) {
+		t.Fatalf("%d errors must be found but %d", len(expected), len(validator.Errors))
+	}
+	for i, err := range validator.Errors {
+		if err.Error() != expected[i] {
+			t.Errorf("error[%d] must be %q but %q", i, expected[i], err.Error())
+		}
+	}
+}
+
+func TestNilValidator(t *testing.T) {
+	var validator *Validator
+	validator.CheckColumn(1, 0, &Column{Code: "Unknown", Type: "UNKNOWN"})
+	validator.CheckCell(2, 0, &Column{Code: "Text", Type: kintone.FT_SINGLE_LINE_TEXT}, "")
+	if err := validator.Show(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestValidatorRequiredFields(t *testing.T) {
+	validator := newValidatorTest()
+	validator.CheckRequired(2, map[string]bool{"Text": true})
+	validator.CheckRequired(3, map[string]bool{"Number": true})
+	if len(validator.Errors) != 1 || validator.Errors[0].Error() != "row[3] - field[Text]: the value is required, but the field is not in the input file" {
+		t.Errorf("the required field which is not in the input must be an error: %v", validator.Errors)
+	}
+}
+
+func TestTimeLayouts(t *testing.T) {
+	// the values accepted by the validation are imported as times, not as texts
+	fieldInfo := &kintone.FieldInfo{Code: "Time", Type: kintone.FT_TIME}
+	for _, value := range []string{"10:30", "10:30:15"} {
+		if message := validateValue(fieldInfo, value); message != "" {
+			t.Errorf("%s must be valid: %s", value, message)
+		}
+		field, ok := getField(kintone.FT_TIME, value).(kintone.TimeField)
+		if !ok || !field.Valid || field.Time.Hour() != 10 || field.Time.Minute() != 30 {
+			t.Errorf("%s must be imported as a time but %#v", value, getField(kintone.FT_TIME, value))
+		}
+	}
+	if message := validateValue(fieldInfo, "10時30分"); message == "" {
+		t.Error("a value which is not imported as a time must be invalid")
+	}
+	if _, ok := getField(kintone.FT_TIME, "10時30分").(kintone.TimeField); ok {
+		t.Error("the invalid value must not be imported as a time")
+	}
+}
+
+func TestValidatorRequiredFile(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	validator := newValidator(map[string]*kintone.FieldInfo{
+		"File": {Code: "File", Type: kintone.FT_FILE, Required: true},
+	})
+	column := &Column{Code: "File", Type: kintone.FT_FILE}
+
+	// the files are uploaded with -b
+	config.FileDir = "files"
+	validator.CheckCell(2, 0, column, "")
+	validator.CheckRequired(2, map[string]bool{"File": true})
+	validator.CheckCell(3, 0, column, "a.txt")
+	validator.CheckRequired(3, map[string]bool{"File": true})
+	// not uploaded without -b
+	config.FileDir = ""
+	validator.CheckCell(4, 0, column, "a.txt")
+	validator.CheckRequired(4, map[string]bool{"File": true})
+
+	expected := []string{
+		"row[2] - column[0] (File): the value is required",
+		"row[4] - field[File]: the value is required, but the files are not uploaded without the -b option",
+	}
+	if len(validator.Errors) != len(expected) {
+		t.Fatalf("%d errors must be found but %v", len(expected), validator.Errors)
+	}
+	for i, err := range validator.Errors {
+		if err.Error() != expected[i] {
+			t.Errorf("error[%d] must be %q but %q", i, expected[i], err.Error())
+		}
+	}
+}
+
+func TestValidateCSV(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	config.Line = 1
+	validator := newValidatorTest()
+	fields := validator.fields
+	// the record to update does not require the field
+	input := "*,$id,Number,Table_time\n*,,1,10:00\n,,,25:00\n*,1,2,\n"
+	if err := validateCSV(strings.NewReader(input), fields, validator); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"row[2] - column[3] (Table_time): '25:00' is not a time (HH:MM:SS or HH:MM)",
+		"row[2] - field[Text]: the value is required, but the field is not in the input file",
+	}
+	if len(validator.Errors) != len(expected) {
+		t.Fatalf("%d errors must be found but %v", len(expected), validator.Errors)
+	}
+	for i, err := range validator.Errors {
+		if err.Error() != expected[i] {
+			t.Errorf("error[%d] must be %q but %q", i, expected[i], err.Error())
+		}
+	}
+}
+
+func TestStrictImport(t *testing.T) {
+	saved := config
+	defer func() { config = saved }()
+	testApp := newTestApp()
+	for _, field := range testApp.Fields {
+		if field.Code == "Text" {
+			field.Required = true
+		}
+	}
+	fake := newFakeKintone(t, testApp)
+	app := fake.App(TEST_APP_ID)
+	config = Configure{}
+	config.AppID = TEST_APP_ID
+	config.Line = 1
+	config.Strict = true
+
+	importPipe := func(input string) error {
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer reader.Close()
+		go func() {
+			writer.WriteString(input)
+			writer.Close()
+		}()
+		return importData(app, reader, nil)
+	}
+
+	// no record is sent before the whole input is validated
+	if err := importPipe("Text,number\naaa,1\n,2\n"); err == nil {
+		t.Error("the missing value must be an error")
+	}
+	if err := importPipe("{\"number\": {\"value\": \"1\"}}\n"); err == nil {
+		t.Error("the missing field must be an error")
+	}
+	if len(fake.Records(TEST_APP_ID)) != 0 {
+		t.Fatal("no record must be imported when the input has errors")
+	}
+
+	if err := importPipe("Text,number\naaa,1\nbbb,2\n"); err != nil {
+		t.Fatal(err)
+	}
+	if len(fake.Records(TEST_APP_ID)) != 2 || config.DryRun || !config.Strict {
+		t.Errorf("the records must be imported after the validation, without changing the options")
+	}
+}